@@ -0,0 +1,233 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the panic value of a stage whose circuit breaker is
+// open and has no fallback. P* terminals wrap it in a *PanicError.
+var ErrCircuitOpen = errors.New("pipe: circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every call until the cooldown has passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a few trial calls through to decide whether to
+	// close again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerPolicy configures CircuitBreaker. A call fails when the wrapped
+// stage panics, as MapRetry, Timeout and ordinary functions do on failure.
+type BreakerPolicy struct {
+	// Window is the number of most recent calls the failure ratio is
+	// computed over; 10 if not set. The breaker does not open before
+	// Window calls have been made.
+	Window int
+	// FailureRatio in (0, 1] opens the breaker; 0.5 if not set.
+	FailureRatio float64
+	// Cooldown is how long the breaker stays open before half-opening.
+	Cooldown time.Duration
+	// HalfOpenCalls is the number of trial calls allowed while half-open,
+	// all of which must succeed to close the breaker; 1 if not set.
+	HalfOpenCalls int
+	// Fallback, if set, is called with the element instead of the stage
+	// while the breaker is open, and in place of the result of a failed
+	// call, so that failures do not stop the terminal. It must have the
+	// signature of a Map function in place of the stage.
+	Fallback interface{}
+	// OnStateChange, if set, is called on every state change. It is
+	// called with the breaker locked and must not block.
+	OnStateChange func(from, to BreakerState)
+}
+
+type _BreakerProc struct {
+	inner    _IProc
+	policy   BreakerPolicy
+	fallback reflect.Value
+	clock    Clock
+
+	mu       sync.Mutex
+	state    BreakerState
+	outcomes []bool
+	next     int
+	failures int
+	openedAt time.Time
+	trials   int
+	passed   int
+}
+
+func (b *_BreakerProc) configure(conf *_Config) _IProc {
+	inner := b.inner
+	if c, ok := inner.(_IConfigurable); ok {
+		inner = c.configure(conf)
+	}
+	window := b.policy.Window
+	if window < 1 {
+		window = 10
+	}
+	return &_BreakerProc{
+		inner:    inner,
+		policy:   b.policy,
+		fallback: b.fallback,
+		clock:    conf.clock,
+		outcomes: make([]bool, 0, window),
+	}
+}
+
+func (b *_BreakerProc) setState(state BreakerState) {
+	from := b.state
+	b.state = state
+	switch state {
+	case BreakerOpen:
+		b.openedAt = b.clock.Now()
+	case BreakerHalfOpen:
+		b.trials, b.passed = 0, 0
+	case BreakerClosed:
+		b.outcomes, b.next, b.failures = b.outcomes[:0], 0, 0
+	}
+	if b.policy.OnStateChange != nil {
+		b.policy.OnStateChange(from, state)
+	}
+}
+
+func (b *_BreakerProc) trialCalls() int {
+	if b.policy.HalfOpenCalls < 1 {
+		return 1
+	}
+	return b.policy.HalfOpenCalls
+}
+
+// allow reports whether a call may go through, counting it as a trial when
+// the breaker is half-open, and the state it was let through in.
+func (b *_BreakerProc) allow() (BreakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.clock.Now().Sub(b.openedAt) >= b.policy.Cooldown {
+		b.setState(BreakerHalfOpen)
+	}
+	switch b.state {
+	case BreakerOpen:
+		return b.state, false
+	case BreakerHalfOpen:
+		if b.trials >= b.trialCalls() {
+			return b.state, false
+		}
+		b.trials++
+	}
+	return b.state, true
+}
+
+func (b *_BreakerProc) record(admitted BreakerState, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != admitted {
+		// the call started before the last state change
+		return
+	}
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.setState(BreakerOpen)
+		} else if b.passed++; b.passed >= b.trialCalls() {
+			b.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		if len(b.outcomes) < cap(b.outcomes) {
+			b.outcomes = append(b.outcomes, failed)
+		} else {
+			if b.outcomes[b.next] {
+				b.failures--
+			}
+			b.outcomes[b.next] = failed
+			b.next = (b.next + 1) % len(b.outcomes)
+		}
+		if failed {
+			b.failures++
+		}
+		ratio := b.policy.FailureRatio
+		if ratio <= 0 {
+			ratio = 0.5
+		}
+		if len(b.outcomes) == cap(b.outcomes) && float64(b.failures) >= ratio*float64(len(b.outcomes)) {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+func (b *_BreakerProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return b.nextAt(-1, input)
+}
+
+func (b *_BreakerProc) nextAt(index int, input reflect.Value) (output reflect.Value, keep bool) {
+	admitted, ok := b.allow()
+	if !ok {
+		if b.fallback.IsValid() {
+			return b.fallback.Call([]reflect.Value{input})[0], true
+		}
+		panic(ErrCircuitOpen)
+	}
+	failed := true
+	defer func() {
+		b.record(admitted, failed)
+		if failed && b.fallback.IsValid() {
+			recover()
+			output, keep = b.fallback.Call([]reflect.Value{input})[0], true
+		}
+	}()
+	output, keep = procNext(b.inner, index, input)
+	failed = false
+	return output, keep
+}
+
+func (b *_BreakerProc) GetOutType() reflect.Type {
+	return b.inner.GetOutType()
+}
+
+func (b *_BreakerProc) describe() _StageDesc {
+	desc := describeProc(b.inner)
+	desc.Kind += " breaker"
+	return desc
+}
+
+// CircuitBreaker stops calling the preceding stage once too many of its
+// recent calls failed: while the breaker is open elements fail fast with
+// ErrCircuitOpen, or go to policy.Fallback. After the cooldown a few trial
+// calls decide whether it closes again. The clock set by WithClock is
+// used.
+func (p *_Pipe) CircuitBreaker(policy BreakerPolicy) *_Pipe {
+	stage, _ := p.lastStage()
+	if p.err != nil || stage.proc == nil {
+		return p.wrapStage("breaker", nil)
+	}
+	var fallback reflect.Value
+	if policy.Fallback != nil {
+		if err := stage.srcPipe.checkFunc("breaker fallback", policy.Fallback, []interface{}{stage.srcPipe.getOutType()}, []interface{}{stage.proc.GetOutType()}); err != nil {
+			return p.withError(err)
+		}
+		fallback = reflect.ValueOf(policy.Fallback)
+	}
+	return p.wrapStage("breaker", func(inner _IProc) _IProc {
+		return &_BreakerProc{inner: inner, policy: policy, fallback: fallback}
+	})
+}
@@ -0,0 +1,11 @@
+// Package example holds pipes generated by pipegen. Its tests mirror the
+// behavioral tests of the reflection based pipe package.
+package example
+
+//go:generate go run github.com/lennon-guan/pipe/cmd/pipegen
+//pipegen:types int string User
+//pipegen:keys int string
+
+type User struct {
+	UserId int
+}
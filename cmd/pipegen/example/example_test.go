@@ -0,0 +1,221 @@
+package example
+
+import (
+	"fmt"
+	"testing"
+)
+
+func intSliceEqual(a []int, b ...int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, va := range a {
+		if b[i] != va {
+			return false
+		}
+	}
+	return true
+}
+
+func strSliceEqual(a []string, b ...string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, va := range a {
+		if b[i] != va {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPToSlice(t *testing.T) {
+	src := []int{1, 2, 3}
+	dst := NewIntPipe(src).
+		MapToString(func(item int) string { return fmt.Sprintf("#%d", item) }).
+		PToSlice()
+	if !strSliceEqual(dst, "#1", "#2", "#3") {
+		t.Error(fmt.Sprintf("wrong dst %v", dst))
+	}
+}
+
+func TestUniq(t *testing.T) {
+	src := []int{1, 2, 1, 2, 3}
+	dst := NewIntPipe(src).Uniq().ToSlice()
+	if !intSliceEqual(dst, 1, 2, 3) {
+		t.Error(fmt.Sprintf("wrong dst %v", dst))
+	}
+}
+
+func TestMap(t *testing.T) {
+	src := []int{1, 2, 3}
+	dst := NewIntPipe(src).
+		MapToString(func(item int) string { return fmt.Sprintf("#%d", item) }).
+		ToSlice()
+	if !strSliceEqual(dst, "#1", "#2", "#3") {
+		t.Error(fmt.Sprintf("wrong dst %v", dst))
+	}
+}
+
+func TestMap3(t *testing.T) {
+	src := []User{User{UserId: 1}, User{UserId: 2}}
+	dst := NewUserPipe(src).
+		MapToInt(func(item User) int { return item.UserId }).
+		ToSlice()
+	if !intSliceEqual(dst, 1, 2) {
+		t.Error(fmt.Sprintf("wrong dst %v", dst))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	src := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	dst := NewIntPipe(src).
+		Filter(func(in int) bool { return in%3 == 0 }).
+		ToSlice()
+	if !intSliceEqual(dst, 3, 6, 9) {
+		t.Error(fmt.Sprintf("wrong dst %v", dst))
+	}
+}
+
+func TestReduce(t *testing.T) {
+	src := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	sum := NewIntPipe(src).Reduce(0, func(s, item int) int { return s + item })
+	if sum != 55 {
+		t.Error(fmt.Sprintf("sum %v != 55", sum))
+	}
+	sum = NewIntPipe(src).PReduce(0, func(s, item int) int { return s + item })
+	if sum != 55 {
+		t.Error(fmt.Sprintf("sum %v != 55", sum))
+	}
+}
+
+func TestMapFilterReduce(t *testing.T) {
+	src := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	p := NewIntPipe(src).
+		Filter(func(in int) bool { return in%3 == 0 }).
+		Map(func(in int) int { return in * in })
+	if dst := p.ToSlice(); !intSliceEqual(dst, 9, 36, 81) {
+		t.Error(fmt.Sprintf("wrong dst %v", dst))
+	}
+	if sum := p.Reduce(0, func(s, item int) int { return s + item }); sum != 126 {
+		t.Error(fmt.Sprintf("sum %v != 126", sum))
+	}
+}
+
+func TestSortMapFilter(t *testing.T) {
+	src := []int{5, 4, 3, 2, 1}
+	dst := NewIntPipe(src).
+		Filter(func(v int) bool { return v%2 != 0 }).
+		Sort(func(a, b int) bool { return a < b }).
+		Map(func(i int) int { return i * i }).
+		ToSlice()
+	if !intSliceEqual(dst, 1, 9, 25) {
+		t.Error(fmt.Sprintf("sort fail %v", dst))
+	}
+}
+
+func TestReverse(t *testing.T) {
+	src := []int{5, 4, 3, 2, 1}
+	dst := NewIntPipe(src).
+		Filter(func(v int) bool { return v > 1 }).
+		Filter(func(v int) bool { return v < 5 }).
+		Reverse().
+		ToSlice()
+	if !intSliceEqual(dst, 2, 3, 4) {
+		t.Error(fmt.Sprintf("reverse fail %v", dst))
+	}
+}
+
+func TestToMap(t *testing.T) {
+	src := []int{5, 4, 3, 2, 1}
+	key := func(v int) string { return fmt.Sprintf("Key-%d", v) }
+	val := func(v int) string { return fmt.Sprintf("Val-%d", v) }
+	for _, dst := range []map[string]string{
+		NewIntPipe(src).ToMapStringString(key, val),
+		NewIntPipe(src).PToMapStringString(key, val),
+	} {
+		if len(dst) != 5 {
+			t.Error("to map fail. len not matched", len(dst), 5)
+		}
+		for i := 1; i <= 5; i++ {
+			if dst[key(i)] != val(i) {
+				t.Error("value wrong", key(i), dst[key(i)])
+			}
+		}
+	}
+}
+
+func TestToGroupMap(t *testing.T) {
+	src := []int{5, 4, 3, 2, 1}
+	key := func(v int) string {
+		if v%2 != 0 {
+			return "odd"
+		}
+		return "even"
+	}
+	val := func(v int) int { return v }
+	for _, dst := range []map[string][]int{
+		NewIntPipe(src).ToGroupMapStringInt(key, val),
+		NewIntPipe(src).PToGroupMapStringInt(key, val),
+	} {
+		if len(dst) != 2 {
+			t.Error("to groupmap fail. len not matched")
+		}
+		if !intSliceEqual(dst["odd"], 5, 3, 1) {
+			t.Error("to groupmap fail.")
+		}
+		if !intSliceEqual(dst["even"], 4, 2) {
+			t.Error("to groupmap fail.")
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	src := []int{1, 2, 3, 4, 5}
+	dst := make([]int, 5)
+	NewIntPipe(src).
+		Map(func(i int) int { return i * i }).
+		Each(func(item, index int) { dst[index] = item })
+	if !intSliceEqual(dst, 1, 4, 9, 16, 25) {
+		t.Error("values wrong")
+	}
+	dst = make([]int, 5)
+	NewIntPipe(src).
+		Map(func(i int) int { return i * i }).
+		PEach(func(item, index int) { dst[index] = item })
+	if !intSliceEqual(dst, 1, 4, 9, 16, 25) {
+		t.Error("values wrong")
+	}
+}
+
+func TestSomeEvery(t *testing.T) {
+	src := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	lessThen := func(max int) func(int) bool {
+		return func(v int) bool { return v < max }
+	}
+	if NewIntPipe(src).Some(lessThen(5), 5) {
+		t.Error("Some(lessThen(5), 5) shoud be false")
+	}
+	if !NewIntPipe(src).Some(lessThen(5), 4) {
+		t.Error("Some(lessThen(5), 4) shoud be true")
+	}
+	if NewIntPipe(src).Every(lessThen(5)) {
+		t.Error("Every(lessThen(5)) shoud be false")
+	}
+	if !NewIntPipe(src).Every(lessThen(11)) {
+		t.Error("Every(lessThen(11)) shoud be true")
+	}
+}
+
+func TestRangeMapFilter(t *testing.T) {
+	if dst := IntRange(1, 6, 2).ToSlice(); !intSliceEqual(dst, 1, 3, 5) {
+		t.Error("IntRange error", dst)
+	}
+	dst := IntRange(0, 10, 1).
+		Map(func(i int) int { return i * 2 }).
+		Filter(func(i int) bool { return i < 10 }).
+		ToSlice()
+	if !intSliceEqual(dst, 0, 2, 4, 6, 8) {
+		t.Error(dst)
+	}
+}
@@ -0,0 +1,1189 @@
+// Code generated by pipegen. DO NOT EDIT.
+
+package example
+
+import (
+	"sort"
+	"sync"
+)
+
+// IntPipe is a reflection-free pipe over int elements.
+type IntPipe struct {
+	n   int
+	get func(int) (int, bool)
+}
+
+func NewIntPipe(src []int) *IntPipe {
+	return &IntPipe{
+		n:   len(src),
+		get: func(i int) (int, bool) { return src[i], true },
+	}
+}
+
+func IntRange(begin, end, step int) *IntPipe {
+	n := 0
+	if step > 0 && end > begin {
+		n = (end - begin + step - 1) / step
+	}
+	return &IntPipe{
+		n:   n,
+		get: func(i int) (int, bool) { return begin + i*step, true },
+	}
+}
+
+func (p *IntPipe) Filter(fn func(int) bool) *IntPipe {
+	get := p.get
+	return &IntPipe{
+		n: p.n,
+		get: func(i int) (int, bool) {
+			v, ok := get(i)
+			return v, ok && fn(v)
+		},
+	}
+}
+
+func (p *IntPipe) Map(fn func(int) int) *IntPipe {
+	get := p.get
+	return &IntPipe{
+		n: p.n,
+		get: func(i int) (int, bool) {
+			v, ok := get(i)
+			if !ok {
+				return v, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *IntPipe) MapToString(fn func(int) string) *StringPipe {
+	get := p.get
+	return &StringPipe{
+		n: p.n,
+		get: func(i int) (out string, keep bool) {
+			v, ok := get(i)
+			if !ok {
+				return out, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *IntPipe) MapToUser(fn func(int) User) *UserPipe {
+	get := p.get
+	return &UserPipe{
+		n: p.n,
+		get: func(i int) (out User, keep bool) {
+			v, ok := get(i)
+			if !ok {
+				return out, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *IntPipe) ToSlice() []int {
+	out := make([]int, 0, p.n)
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToSlice() []int {
+	vals := make([]int, p.n)
+	keeps := make([]bool, p.n)
+	var wg sync.WaitGroup
+	wg.Add(p.n)
+	for i := 0; i < p.n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			vals[i], keeps[i] = p.get(i)
+		}(i)
+	}
+	wg.Wait()
+	out := vals[:0]
+	for i, keep := range keeps {
+		if keep {
+			out = append(out, vals[i])
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) Each(fn func(int, int)) {
+	index := 0
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			fn(v, index)
+			index++
+		}
+	}
+}
+
+func (p *IntPipe) PEach(fn func(int, int)) {
+	var wg sync.WaitGroup
+	for index, v := range p.PToSlice() {
+		wg.Add(1)
+		go func(v int, index int) {
+			defer wg.Done()
+			fn(v, index)
+		}(v, index)
+	}
+	wg.Wait()
+}
+
+func (p *IntPipe) ReduceToInt(init int, fn func(int, int) int) int {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *IntPipe) PReduceToInt(init int, fn func(int, int) int) int {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *IntPipe) ReduceToString(init string, fn func(string, int) string) string {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *IntPipe) PReduceToString(init string, fn func(string, int) string) string {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *IntPipe) ReduceToUser(init User, fn func(User, int) User) User {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *IntPipe) PReduceToUser(init User, fn func(User, int) User) User {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *IntPipe) Reduce(init int, fn func(int, int) int) int {
+	return p.ReduceToInt(init, fn)
+}
+
+func (p *IntPipe) PReduce(init int, fn func(int, int) int) int {
+	return p.PReduceToInt(init, fn)
+}
+
+func (p *IntPipe) ToMapIntInt(getKey func(int) int, getVal func(int) int) map[int]int {
+	out := make(map[int]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToMapIntInt(getKey func(int) int, getVal func(int) int) map[int]int {
+	return NewIntPipe(p.PToSlice()).ToMapIntInt(getKey, getVal)
+}
+
+func (p *IntPipe) ToGroupMapIntInt(getKey func(int) int, getVal func(int) int) map[int][]int {
+	out := make(map[int][]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToGroupMapIntInt(getKey func(int) int, getVal func(int) int) map[int][]int {
+	return NewIntPipe(p.PToSlice()).ToGroupMapIntInt(getKey, getVal)
+}
+
+func (p *IntPipe) ToMapIntString(getKey func(int) int, getVal func(int) string) map[int]string {
+	out := make(map[int]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToMapIntString(getKey func(int) int, getVal func(int) string) map[int]string {
+	return NewIntPipe(p.PToSlice()).ToMapIntString(getKey, getVal)
+}
+
+func (p *IntPipe) ToGroupMapIntString(getKey func(int) int, getVal func(int) string) map[int][]string {
+	out := make(map[int][]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToGroupMapIntString(getKey func(int) int, getVal func(int) string) map[int][]string {
+	return NewIntPipe(p.PToSlice()).ToGroupMapIntString(getKey, getVal)
+}
+
+func (p *IntPipe) ToMapIntUser(getKey func(int) int, getVal func(int) User) map[int]User {
+	out := make(map[int]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToMapIntUser(getKey func(int) int, getVal func(int) User) map[int]User {
+	return NewIntPipe(p.PToSlice()).ToMapIntUser(getKey, getVal)
+}
+
+func (p *IntPipe) ToGroupMapIntUser(getKey func(int) int, getVal func(int) User) map[int][]User {
+	out := make(map[int][]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToGroupMapIntUser(getKey func(int) int, getVal func(int) User) map[int][]User {
+	return NewIntPipe(p.PToSlice()).ToGroupMapIntUser(getKey, getVal)
+}
+
+func (p *IntPipe) ToMapStringInt(getKey func(int) string, getVal func(int) int) map[string]int {
+	out := make(map[string]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToMapStringInt(getKey func(int) string, getVal func(int) int) map[string]int {
+	return NewIntPipe(p.PToSlice()).ToMapStringInt(getKey, getVal)
+}
+
+func (p *IntPipe) ToGroupMapStringInt(getKey func(int) string, getVal func(int) int) map[string][]int {
+	out := make(map[string][]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToGroupMapStringInt(getKey func(int) string, getVal func(int) int) map[string][]int {
+	return NewIntPipe(p.PToSlice()).ToGroupMapStringInt(getKey, getVal)
+}
+
+func (p *IntPipe) ToMapStringString(getKey func(int) string, getVal func(int) string) map[string]string {
+	out := make(map[string]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToMapStringString(getKey func(int) string, getVal func(int) string) map[string]string {
+	return NewIntPipe(p.PToSlice()).ToMapStringString(getKey, getVal)
+}
+
+func (p *IntPipe) ToGroupMapStringString(getKey func(int) string, getVal func(int) string) map[string][]string {
+	out := make(map[string][]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToGroupMapStringString(getKey func(int) string, getVal func(int) string) map[string][]string {
+	return NewIntPipe(p.PToSlice()).ToGroupMapStringString(getKey, getVal)
+}
+
+func (p *IntPipe) ToMapStringUser(getKey func(int) string, getVal func(int) User) map[string]User {
+	out := make(map[string]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToMapStringUser(getKey func(int) string, getVal func(int) User) map[string]User {
+	return NewIntPipe(p.PToSlice()).ToMapStringUser(getKey, getVal)
+}
+
+func (p *IntPipe) ToGroupMapStringUser(getKey func(int) string, getVal func(int) User) map[string][]User {
+	out := make(map[string][]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *IntPipe) PToGroupMapStringUser(getKey func(int) string, getVal func(int) User) map[string][]User {
+	return NewIntPipe(p.PToSlice()).ToGroupMapStringUser(getKey, getVal)
+}
+
+func (p *IntPipe) Some(fn func(int) bool, atLeast int) bool {
+	fulfillNum := 0
+	for i := 0; i < p.n && fulfillNum < atLeast; i++ {
+		if v, ok := p.get(i); ok && fn(v) {
+			fulfillNum++
+		}
+	}
+	return fulfillNum >= atLeast
+}
+
+func (p *IntPipe) Every(fn func(int) bool) bool {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok && !fn(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *IntPipe) Sort(less func(a, b int) bool) *IntPipe {
+	out := p.ToSlice()
+	sort.SliceStable(out, func(i, j int) bool { return less(out[i], out[j]) })
+	return NewIntPipe(out)
+}
+
+func (p *IntPipe) Reverse() *IntPipe {
+	out := p.ToSlice()
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return NewIntPipe(out)
+}
+
+func (p *IntPipe) Uniq() *IntPipe {
+	out := make([]int, 0, p.n)
+	exists := make(map[int]bool)
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok && !exists[v] {
+			exists[v] = true
+			out = append(out, v)
+		}
+	}
+	return NewIntPipe(out)
+}
+
+// StringPipe is a reflection-free pipe over string elements.
+type StringPipe struct {
+	n   int
+	get func(int) (string, bool)
+}
+
+func NewStringPipe(src []string) *StringPipe {
+	return &StringPipe{
+		n:   len(src),
+		get: func(i int) (string, bool) { return src[i], true },
+	}
+}
+
+func (p *StringPipe) Filter(fn func(string) bool) *StringPipe {
+	get := p.get
+	return &StringPipe{
+		n: p.n,
+		get: func(i int) (string, bool) {
+			v, ok := get(i)
+			return v, ok && fn(v)
+		},
+	}
+}
+
+func (p *StringPipe) Map(fn func(string) string) *StringPipe {
+	get := p.get
+	return &StringPipe{
+		n: p.n,
+		get: func(i int) (string, bool) {
+			v, ok := get(i)
+			if !ok {
+				return v, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *StringPipe) MapToInt(fn func(string) int) *IntPipe {
+	get := p.get
+	return &IntPipe{
+		n: p.n,
+		get: func(i int) (out int, keep bool) {
+			v, ok := get(i)
+			if !ok {
+				return out, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *StringPipe) MapToUser(fn func(string) User) *UserPipe {
+	get := p.get
+	return &UserPipe{
+		n: p.n,
+		get: func(i int) (out User, keep bool) {
+			v, ok := get(i)
+			if !ok {
+				return out, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *StringPipe) ToSlice() []string {
+	out := make([]string, 0, p.n)
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToSlice() []string {
+	vals := make([]string, p.n)
+	keeps := make([]bool, p.n)
+	var wg sync.WaitGroup
+	wg.Add(p.n)
+	for i := 0; i < p.n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			vals[i], keeps[i] = p.get(i)
+		}(i)
+	}
+	wg.Wait()
+	out := vals[:0]
+	for i, keep := range keeps {
+		if keep {
+			out = append(out, vals[i])
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) Each(fn func(string, int)) {
+	index := 0
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			fn(v, index)
+			index++
+		}
+	}
+}
+
+func (p *StringPipe) PEach(fn func(string, int)) {
+	var wg sync.WaitGroup
+	for index, v := range p.PToSlice() {
+		wg.Add(1)
+		go func(v string, index int) {
+			defer wg.Done()
+			fn(v, index)
+		}(v, index)
+	}
+	wg.Wait()
+}
+
+func (p *StringPipe) ReduceToInt(init int, fn func(int, string) int) int {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *StringPipe) PReduceToInt(init int, fn func(int, string) int) int {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *StringPipe) ReduceToString(init string, fn func(string, string) string) string {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *StringPipe) PReduceToString(init string, fn func(string, string) string) string {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *StringPipe) ReduceToUser(init User, fn func(User, string) User) User {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *StringPipe) PReduceToUser(init User, fn func(User, string) User) User {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *StringPipe) Reduce(init string, fn func(string, string) string) string {
+	return p.ReduceToString(init, fn)
+}
+
+func (p *StringPipe) PReduce(init string, fn func(string, string) string) string {
+	return p.PReduceToString(init, fn)
+}
+
+func (p *StringPipe) ToMapIntInt(getKey func(string) int, getVal func(string) int) map[int]int {
+	out := make(map[int]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToMapIntInt(getKey func(string) int, getVal func(string) int) map[int]int {
+	return NewStringPipe(p.PToSlice()).ToMapIntInt(getKey, getVal)
+}
+
+func (p *StringPipe) ToGroupMapIntInt(getKey func(string) int, getVal func(string) int) map[int][]int {
+	out := make(map[int][]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToGroupMapIntInt(getKey func(string) int, getVal func(string) int) map[int][]int {
+	return NewStringPipe(p.PToSlice()).ToGroupMapIntInt(getKey, getVal)
+}
+
+func (p *StringPipe) ToMapIntString(getKey func(string) int, getVal func(string) string) map[int]string {
+	out := make(map[int]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToMapIntString(getKey func(string) int, getVal func(string) string) map[int]string {
+	return NewStringPipe(p.PToSlice()).ToMapIntString(getKey, getVal)
+}
+
+func (p *StringPipe) ToGroupMapIntString(getKey func(string) int, getVal func(string) string) map[int][]string {
+	out := make(map[int][]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToGroupMapIntString(getKey func(string) int, getVal func(string) string) map[int][]string {
+	return NewStringPipe(p.PToSlice()).ToGroupMapIntString(getKey, getVal)
+}
+
+func (p *StringPipe) ToMapIntUser(getKey func(string) int, getVal func(string) User) map[int]User {
+	out := make(map[int]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToMapIntUser(getKey func(string) int, getVal func(string) User) map[int]User {
+	return NewStringPipe(p.PToSlice()).ToMapIntUser(getKey, getVal)
+}
+
+func (p *StringPipe) ToGroupMapIntUser(getKey func(string) int, getVal func(string) User) map[int][]User {
+	out := make(map[int][]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToGroupMapIntUser(getKey func(string) int, getVal func(string) User) map[int][]User {
+	return NewStringPipe(p.PToSlice()).ToGroupMapIntUser(getKey, getVal)
+}
+
+func (p *StringPipe) ToMapStringInt(getKey func(string) string, getVal func(string) int) map[string]int {
+	out := make(map[string]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToMapStringInt(getKey func(string) string, getVal func(string) int) map[string]int {
+	return NewStringPipe(p.PToSlice()).ToMapStringInt(getKey, getVal)
+}
+
+func (p *StringPipe) ToGroupMapStringInt(getKey func(string) string, getVal func(string) int) map[string][]int {
+	out := make(map[string][]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToGroupMapStringInt(getKey func(string) string, getVal func(string) int) map[string][]int {
+	return NewStringPipe(p.PToSlice()).ToGroupMapStringInt(getKey, getVal)
+}
+
+func (p *StringPipe) ToMapStringString(getKey func(string) string, getVal func(string) string) map[string]string {
+	out := make(map[string]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToMapStringString(getKey func(string) string, getVal func(string) string) map[string]string {
+	return NewStringPipe(p.PToSlice()).ToMapStringString(getKey, getVal)
+}
+
+func (p *StringPipe) ToGroupMapStringString(getKey func(string) string, getVal func(string) string) map[string][]string {
+	out := make(map[string][]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToGroupMapStringString(getKey func(string) string, getVal func(string) string) map[string][]string {
+	return NewStringPipe(p.PToSlice()).ToGroupMapStringString(getKey, getVal)
+}
+
+func (p *StringPipe) ToMapStringUser(getKey func(string) string, getVal func(string) User) map[string]User {
+	out := make(map[string]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToMapStringUser(getKey func(string) string, getVal func(string) User) map[string]User {
+	return NewStringPipe(p.PToSlice()).ToMapStringUser(getKey, getVal)
+}
+
+func (p *StringPipe) ToGroupMapStringUser(getKey func(string) string, getVal func(string) User) map[string][]User {
+	out := make(map[string][]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *StringPipe) PToGroupMapStringUser(getKey func(string) string, getVal func(string) User) map[string][]User {
+	return NewStringPipe(p.PToSlice()).ToGroupMapStringUser(getKey, getVal)
+}
+
+func (p *StringPipe) Some(fn func(string) bool, atLeast int) bool {
+	fulfillNum := 0
+	for i := 0; i < p.n && fulfillNum < atLeast; i++ {
+		if v, ok := p.get(i); ok && fn(v) {
+			fulfillNum++
+		}
+	}
+	return fulfillNum >= atLeast
+}
+
+func (p *StringPipe) Every(fn func(string) bool) bool {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok && !fn(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *StringPipe) Sort(less func(a, b string) bool) *StringPipe {
+	out := p.ToSlice()
+	sort.SliceStable(out, func(i, j int) bool { return less(out[i], out[j]) })
+	return NewStringPipe(out)
+}
+
+func (p *StringPipe) Reverse() *StringPipe {
+	out := p.ToSlice()
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return NewStringPipe(out)
+}
+
+func (p *StringPipe) Uniq() *StringPipe {
+	out := make([]string, 0, p.n)
+	exists := make(map[string]bool)
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok && !exists[v] {
+			exists[v] = true
+			out = append(out, v)
+		}
+	}
+	return NewStringPipe(out)
+}
+
+// UserPipe is a reflection-free pipe over User elements.
+type UserPipe struct {
+	n   int
+	get func(int) (User, bool)
+}
+
+func NewUserPipe(src []User) *UserPipe {
+	return &UserPipe{
+		n:   len(src),
+		get: func(i int) (User, bool) { return src[i], true },
+	}
+}
+
+func (p *UserPipe) Filter(fn func(User) bool) *UserPipe {
+	get := p.get
+	return &UserPipe{
+		n: p.n,
+		get: func(i int) (User, bool) {
+			v, ok := get(i)
+			return v, ok && fn(v)
+		},
+	}
+}
+
+func (p *UserPipe) Map(fn func(User) User) *UserPipe {
+	get := p.get
+	return &UserPipe{
+		n: p.n,
+		get: func(i int) (User, bool) {
+			v, ok := get(i)
+			if !ok {
+				return v, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *UserPipe) MapToInt(fn func(User) int) *IntPipe {
+	get := p.get
+	return &IntPipe{
+		n: p.n,
+		get: func(i int) (out int, keep bool) {
+			v, ok := get(i)
+			if !ok {
+				return out, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *UserPipe) MapToString(fn func(User) string) *StringPipe {
+	get := p.get
+	return &StringPipe{
+		n: p.n,
+		get: func(i int) (out string, keep bool) {
+			v, ok := get(i)
+			if !ok {
+				return out, false
+			}
+			return fn(v), true
+		},
+	}
+}
+
+func (p *UserPipe) ToSlice() []User {
+	out := make([]User, 0, p.n)
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToSlice() []User {
+	vals := make([]User, p.n)
+	keeps := make([]bool, p.n)
+	var wg sync.WaitGroup
+	wg.Add(p.n)
+	for i := 0; i < p.n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			vals[i], keeps[i] = p.get(i)
+		}(i)
+	}
+	wg.Wait()
+	out := vals[:0]
+	for i, keep := range keeps {
+		if keep {
+			out = append(out, vals[i])
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) Each(fn func(User, int)) {
+	index := 0
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			fn(v, index)
+			index++
+		}
+	}
+}
+
+func (p *UserPipe) PEach(fn func(User, int)) {
+	var wg sync.WaitGroup
+	for index, v := range p.PToSlice() {
+		wg.Add(1)
+		go func(v User, index int) {
+			defer wg.Done()
+			fn(v, index)
+		}(v, index)
+	}
+	wg.Wait()
+}
+
+func (p *UserPipe) ReduceToInt(init int, fn func(int, User) int) int {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *UserPipe) PReduceToInt(init int, fn func(int, User) int) int {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *UserPipe) ReduceToString(init string, fn func(string, User) string) string {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *UserPipe) PReduceToString(init string, fn func(string, User) string) string {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *UserPipe) ReduceToUser(init User, fn func(User, User) User) User {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *UserPipe) PReduceToUser(init User, fn func(User, User) User) User {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+
+func (p *UserPipe) Reduce(init User, fn func(User, User) User) User {
+	return p.ReduceToUser(init, fn)
+}
+
+func (p *UserPipe) PReduce(init User, fn func(User, User) User) User {
+	return p.PReduceToUser(init, fn)
+}
+
+func (p *UserPipe) ToMapIntInt(getKey func(User) int, getVal func(User) int) map[int]int {
+	out := make(map[int]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToMapIntInt(getKey func(User) int, getVal func(User) int) map[int]int {
+	return NewUserPipe(p.PToSlice()).ToMapIntInt(getKey, getVal)
+}
+
+func (p *UserPipe) ToGroupMapIntInt(getKey func(User) int, getVal func(User) int) map[int][]int {
+	out := make(map[int][]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToGroupMapIntInt(getKey func(User) int, getVal func(User) int) map[int][]int {
+	return NewUserPipe(p.PToSlice()).ToGroupMapIntInt(getKey, getVal)
+}
+
+func (p *UserPipe) ToMapIntString(getKey func(User) int, getVal func(User) string) map[int]string {
+	out := make(map[int]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToMapIntString(getKey func(User) int, getVal func(User) string) map[int]string {
+	return NewUserPipe(p.PToSlice()).ToMapIntString(getKey, getVal)
+}
+
+func (p *UserPipe) ToGroupMapIntString(getKey func(User) int, getVal func(User) string) map[int][]string {
+	out := make(map[int][]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToGroupMapIntString(getKey func(User) int, getVal func(User) string) map[int][]string {
+	return NewUserPipe(p.PToSlice()).ToGroupMapIntString(getKey, getVal)
+}
+
+func (p *UserPipe) ToMapIntUser(getKey func(User) int, getVal func(User) User) map[int]User {
+	out := make(map[int]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToMapIntUser(getKey func(User) int, getVal func(User) User) map[int]User {
+	return NewUserPipe(p.PToSlice()).ToMapIntUser(getKey, getVal)
+}
+
+func (p *UserPipe) ToGroupMapIntUser(getKey func(User) int, getVal func(User) User) map[int][]User {
+	out := make(map[int][]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToGroupMapIntUser(getKey func(User) int, getVal func(User) User) map[int][]User {
+	return NewUserPipe(p.PToSlice()).ToGroupMapIntUser(getKey, getVal)
+}
+
+func (p *UserPipe) ToMapStringInt(getKey func(User) string, getVal func(User) int) map[string]int {
+	out := make(map[string]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToMapStringInt(getKey func(User) string, getVal func(User) int) map[string]int {
+	return NewUserPipe(p.PToSlice()).ToMapStringInt(getKey, getVal)
+}
+
+func (p *UserPipe) ToGroupMapStringInt(getKey func(User) string, getVal func(User) int) map[string][]int {
+	out := make(map[string][]int)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToGroupMapStringInt(getKey func(User) string, getVal func(User) int) map[string][]int {
+	return NewUserPipe(p.PToSlice()).ToGroupMapStringInt(getKey, getVal)
+}
+
+func (p *UserPipe) ToMapStringString(getKey func(User) string, getVal func(User) string) map[string]string {
+	out := make(map[string]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToMapStringString(getKey func(User) string, getVal func(User) string) map[string]string {
+	return NewUserPipe(p.PToSlice()).ToMapStringString(getKey, getVal)
+}
+
+func (p *UserPipe) ToGroupMapStringString(getKey func(User) string, getVal func(User) string) map[string][]string {
+	out := make(map[string][]string)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToGroupMapStringString(getKey func(User) string, getVal func(User) string) map[string][]string {
+	return NewUserPipe(p.PToSlice()).ToGroupMapStringString(getKey, getVal)
+}
+
+func (p *UserPipe) ToMapStringUser(getKey func(User) string, getVal func(User) User) map[string]User {
+	out := make(map[string]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToMapStringUser(getKey func(User) string, getVal func(User) User) map[string]User {
+	return NewUserPipe(p.PToSlice()).ToMapStringUser(getKey, getVal)
+}
+
+func (p *UserPipe) ToGroupMapStringUser(getKey func(User) string, getVal func(User) User) map[string][]User {
+	out := make(map[string][]User)
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *UserPipe) PToGroupMapStringUser(getKey func(User) string, getVal func(User) User) map[string][]User {
+	return NewUserPipe(p.PToSlice()).ToGroupMapStringUser(getKey, getVal)
+}
+
+func (p *UserPipe) Some(fn func(User) bool, atLeast int) bool {
+	fulfillNum := 0
+	for i := 0; i < p.n && fulfillNum < atLeast; i++ {
+		if v, ok := p.get(i); ok && fn(v) {
+			fulfillNum++
+		}
+	}
+	return fulfillNum >= atLeast
+}
+
+func (p *UserPipe) Every(fn func(User) bool) bool {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok && !fn(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *UserPipe) Sort(less func(a, b User) bool) *UserPipe {
+	out := p.ToSlice()
+	sort.SliceStable(out, func(i, j int) bool { return less(out[i], out[j]) })
+	return NewUserPipe(out)
+}
+
+func (p *UserPipe) Reverse() *UserPipe {
+	out := p.ToSlice()
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return NewUserPipe(out)
+}
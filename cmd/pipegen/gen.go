@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	typesDirective = "//pipegen:types"
+	keysDirective  = "//pipegen:keys"
+)
+
+type typeSpec struct {
+	Type string
+	Name string
+}
+
+type config struct {
+	Package string
+	Types   []typeSpec
+	Keys    []typeSpec
+}
+
+var typeRegexp = regexp.MustCompile(`^\*?[A-Za-z_][A-Za-z0-9_]*$`)
+
+func parseTypes(fields []string) ([]typeSpec, error) {
+	specs := make([]typeSpec, 0, len(fields))
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !typeRegexp.MatchString(f) {
+			return nil, fmt.Errorf("unsupported type %q: only named types of the package and builtins are allowed", f)
+		}
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		name := strings.TrimPrefix(f, "*")
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if strings.HasPrefix(f, "*") {
+			name += "Ptr"
+		}
+		specs = append(specs, typeSpec{Type: f, Name: name})
+	}
+	return specs, nil
+}
+
+// loadConfig reads the package name and the pipegen directives from the
+// non-test Go files in dir, skipping the generated output file itself.
+func loadConfig(dir, output string) (*config, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	var keys []string
+	hasKeys := false
+	var types []string
+	fset := token.NewFileSet()
+	for _, file := range files {
+		base := filepath.Base(file)
+		if base == output || strings.HasSuffix(base, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Package == "" {
+			cfg.Package = f.Name.Name
+		}
+		for _, group := range f.Comments {
+			for _, c := range group.List {
+				if strings.HasPrefix(c.Text, typesDirective) {
+					types = append(types, strings.Fields(strings.TrimPrefix(c.Text, typesDirective))...)
+				} else if strings.HasPrefix(c.Text, keysDirective) {
+					hasKeys = true
+					keys = append(keys, strings.Fields(strings.TrimPrefix(c.Text, keysDirective))...)
+				}
+			}
+		}
+	}
+	if cfg.Package == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	if cfg.Types, err = parseTypes(types); err != nil {
+		return nil, err
+	}
+	if !hasKeys {
+		keys = types
+	}
+	if cfg.Keys, err = parseTypes(keys); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func generate(cfg *config) ([]byte, error) {
+	if len(cfg.Types) == 0 {
+		return nil, fmt.Errorf("no element types declared, add a %s directive", typesDirective)
+	}
+	for _, k := range cfg.Keys {
+		found := false
+		for _, t := range cfg.Types {
+			found = found || t == k
+		}
+		if !found {
+			return nil, fmt.Errorf("key type %s is not declared in %s", k.Type, typesDirective)
+		}
+	}
+	var buf bytes.Buffer
+	if err := pipeTemplate.Execute(&buf, cfg); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// IsKey reports whether t may be used as a map key or with Uniq.
+func (cfg *config) IsKey(t typeSpec) bool {
+	for _, k := range cfg.Keys {
+		if k == t {
+			return true
+		}
+	}
+	return false
+}
+
+var pipeTemplate = template.Must(template.New("pipe").Parse(pipeTemplateText))
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateExampleUpToDate(t *testing.T) {
+	cfg, err := loadConfig("example", "pipegen_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Package != "example" || len(cfg.Types) != 3 || len(cfg.Keys) != 2 {
+		t.Fatalf("wrong config %+v", cfg)
+	}
+	src, err := generate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("example", "pipegen_gen.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Error("example/pipegen_gen.go is out of date, run go generate")
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	specs, err := parseTypes([]string{"int", "*User", "int", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 || specs[0].Name != "Int" || specs[1].Name != "UserPtr" {
+		t.Errorf("wrong specs %+v", specs)
+	}
+	if _, err := parseTypes([]string{"map[string]int"}); err == nil {
+		t.Error("composite types should be rejected")
+	}
+	if _, err := generate(&config{Package: "x"}); err == nil {
+		t.Error("generate without types should fail")
+	}
+}
+
+func TestLoadConfigDirectiveAfterImports(t *testing.T) {
+	dir := t.TempDir()
+	src := "package jobs\n\nimport \"fmt\"\n\nvar _ = fmt.Sprint\n\n//pipegen:types int string\n//pipegen:keys string\n"
+	if err := os.WriteFile(filepath.Join(dir, "jobs.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(dir, "pipegen_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Package != "jobs" || len(cfg.Types) != 2 || len(cfg.Keys) != 1 {
+		t.Fatalf("wrong config %+v", cfg)
+	}
+	if _, err := generate(cfg); err != nil {
+		t.Error(err)
+	}
+}
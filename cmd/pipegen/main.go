@@ -0,0 +1,58 @@
+// Command pipegen generates type-specialized, reflection-free pipes.
+//
+// Declare the element types in any Go file of the package with a directive
+// comment and add a go:generate line:
+//
+//	//go:generate pipegen
+//	//pipegen:types int string User
+//	//pipegen:keys int string
+//
+// For every type T listed in pipegen:types a TPipe type is emitted with the
+// same API as the reflection based pipe: Map, MapToX, Filter, ToSlice,
+// Reduce, ToMapKV, ToGroupMapKV, Sort, Uniq, Reverse, Each, Some, Every and
+// the P* variants. pipegen:keys lists the comparable types that may be used
+// as map keys and with Uniq; it defaults to all declared types.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "package directory")
+	output := flag.String("o", "pipegen_gen.go", "output file name, relative to -dir")
+	types := flag.String("types", "", "comma separated element types, overrides pipegen:types")
+	keys := flag.String("keys", "", "comma separated key types, overrides pipegen:keys")
+	flag.Parse()
+
+	cfg, err := loadConfig(*dir, *output)
+	if err != nil {
+		fatal(err)
+	}
+	if *types != "" {
+		if cfg.Types, err = parseTypes(strings.Split(*types, ",")); err != nil {
+			fatal(err)
+		}
+	}
+	if *keys != "" {
+		if cfg.Keys, err = parseTypes(strings.Split(*keys, ",")); err != nil {
+			fatal(err)
+		}
+	}
+	src, err := generate(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, *output), src, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "pipegen:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,225 @@
+package main
+
+const pipeTemplateText = `// Code generated by pipegen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"sort"
+	"sync"
+)
+{{range $t := .Types}}
+// {{$t.Name}}Pipe is a reflection-free pipe over {{$t.Type}} elements.
+type {{$t.Name}}Pipe struct {
+	n   int
+	get func(int) ({{$t.Type}}, bool)
+}
+
+func New{{$t.Name}}Pipe(src []{{$t.Type}}) *{{$t.Name}}Pipe {
+	return &{{$t.Name}}Pipe{
+		n:   len(src),
+		get: func(i int) ({{$t.Type}}, bool) { return src[i], true },
+	}
+}
+{{if eq $t.Type "int"}}
+func {{$t.Name}}Range(begin, end, step int) *{{$t.Name}}Pipe {
+	n := 0
+	if step > 0 && end > begin {
+		n = (end - begin + step - 1) / step
+	}
+	return &{{$t.Name}}Pipe{
+		n:   n,
+		get: func(i int) (int, bool) { return begin + i*step, true },
+	}
+}
+{{end}}
+func (p *{{$t.Name}}Pipe) Filter(fn func({{$t.Type}}) bool) *{{$t.Name}}Pipe {
+	get := p.get
+	return &{{$t.Name}}Pipe{
+		n: p.n,
+		get: func(i int) ({{$t.Type}}, bool) {
+			v, ok := get(i)
+			return v, ok && fn(v)
+		},
+	}
+}
+
+func (p *{{$t.Name}}Pipe) Map(fn func({{$t.Type}}) {{$t.Type}}) *{{$t.Name}}Pipe {
+	get := p.get
+	return &{{$t.Name}}Pipe{
+		n: p.n,
+		get: func(i int) ({{$t.Type}}, bool) {
+			v, ok := get(i)
+			if !ok {
+				return v, false
+			}
+			return fn(v), true
+		},
+	}
+}
+{{range $m := $.Types}}{{if ne $m.Type $t.Type}}
+func (p *{{$t.Name}}Pipe) MapTo{{$m.Name}}(fn func({{$t.Type}}) {{$m.Type}}) *{{$m.Name}}Pipe {
+	get := p.get
+	return &{{$m.Name}}Pipe{
+		n: p.n,
+		get: func(i int) (out {{$m.Type}}, keep bool) {
+			v, ok := get(i)
+			if !ok {
+				return out, false
+			}
+			return fn(v), true
+		},
+	}
+}
+{{end}}{{end}}
+func (p *{{$t.Name}}Pipe) ToSlice() []{{$t.Type}} {
+	out := make([]{{$t.Type}}, 0, p.n)
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (p *{{$t.Name}}Pipe) PToSlice() []{{$t.Type}} {
+	vals := make([]{{$t.Type}}, p.n)
+	keeps := make([]bool, p.n)
+	var wg sync.WaitGroup
+	wg.Add(p.n)
+	for i := 0; i < p.n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			vals[i], keeps[i] = p.get(i)
+		}(i)
+	}
+	wg.Wait()
+	out := vals[:0]
+	for i, keep := range keeps {
+		if keep {
+			out = append(out, vals[i])
+		}
+	}
+	return out
+}
+
+func (p *{{$t.Name}}Pipe) Each(fn func({{$t.Type}}, int)) {
+	index := 0
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			fn(v, index)
+			index++
+		}
+	}
+}
+
+func (p *{{$t.Name}}Pipe) PEach(fn func({{$t.Type}}, int)) {
+	var wg sync.WaitGroup
+	for index, v := range p.PToSlice() {
+		wg.Add(1)
+		go func(v {{$t.Type}}, index int) {
+			defer wg.Done()
+			fn(v, index)
+		}(v, index)
+	}
+	wg.Wait()
+}
+{{range $m := $.Types}}
+func (p *{{$t.Name}}Pipe) ReduceTo{{$m.Name}}(init {{$m.Type}}, fn func({{$m.Type}}, {{$t.Type}}) {{$m.Type}}) {{$m.Type}} {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok {
+			init = fn(init, v)
+		}
+	}
+	return init
+}
+
+func (p *{{$t.Name}}Pipe) PReduceTo{{$m.Name}}(init {{$m.Type}}, fn func({{$m.Type}}, {{$t.Type}}) {{$m.Type}}) {{$m.Type}} {
+	for _, v := range p.PToSlice() {
+		init = fn(init, v)
+	}
+	return init
+}
+{{end}}
+func (p *{{$t.Name}}Pipe) Reduce(init {{$t.Type}}, fn func({{$t.Type}}, {{$t.Type}}) {{$t.Type}}) {{$t.Type}} {
+	return p.ReduceTo{{$t.Name}}(init, fn)
+}
+
+func (p *{{$t.Name}}Pipe) PReduce(init {{$t.Type}}, fn func({{$t.Type}}, {{$t.Type}}) {{$t.Type}}) {{$t.Type}} {
+	return p.PReduceTo{{$t.Name}}(init, fn)
+}
+{{range $k := $.Keys}}{{range $v := $.Types}}
+func (p *{{$t.Name}}Pipe) ToMap{{$k.Name}}{{$v.Name}}(getKey func({{$t.Type}}) {{$k.Type}}, getVal func({{$t.Type}}) {{$v.Type}}) map[{{$k.Type}}]{{$v.Type}} {
+	out := make(map[{{$k.Type}}]{{$v.Type}})
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			out[getKey(item)] = getVal(item)
+		}
+	}
+	return out
+}
+
+func (p *{{$t.Name}}Pipe) PToMap{{$k.Name}}{{$v.Name}}(getKey func({{$t.Type}}) {{$k.Type}}, getVal func({{$t.Type}}) {{$v.Type}}) map[{{$k.Type}}]{{$v.Type}} {
+	return New{{$t.Name}}Pipe(p.PToSlice()).ToMap{{$k.Name}}{{$v.Name}}(getKey, getVal)
+}
+
+func (p *{{$t.Name}}Pipe) ToGroupMap{{$k.Name}}{{$v.Name}}(getKey func({{$t.Type}}) {{$k.Type}}, getVal func({{$t.Type}}) {{$v.Type}}) map[{{$k.Type}}][]{{$v.Type}} {
+	out := make(map[{{$k.Type}}][]{{$v.Type}})
+	for i := 0; i < p.n; i++ {
+		if item, ok := p.get(i); ok {
+			key := getKey(item)
+			out[key] = append(out[key], getVal(item))
+		}
+	}
+	return out
+}
+
+func (p *{{$t.Name}}Pipe) PToGroupMap{{$k.Name}}{{$v.Name}}(getKey func({{$t.Type}}) {{$k.Type}}, getVal func({{$t.Type}}) {{$v.Type}}) map[{{$k.Type}}][]{{$v.Type}} {
+	return New{{$t.Name}}Pipe(p.PToSlice()).ToGroupMap{{$k.Name}}{{$v.Name}}(getKey, getVal)
+}
+{{end}}{{end}}
+func (p *{{$t.Name}}Pipe) Some(fn func({{$t.Type}}) bool, atLeast int) bool {
+	fulfillNum := 0
+	for i := 0; i < p.n && fulfillNum < atLeast; i++ {
+		if v, ok := p.get(i); ok && fn(v) {
+			fulfillNum++
+		}
+	}
+	return fulfillNum >= atLeast
+}
+
+func (p *{{$t.Name}}Pipe) Every(fn func({{$t.Type}}) bool) bool {
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok && !fn(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *{{$t.Name}}Pipe) Sort(less func(a, b {{$t.Type}}) bool) *{{$t.Name}}Pipe {
+	out := p.ToSlice()
+	sort.SliceStable(out, func(i, j int) bool { return less(out[i], out[j]) })
+	return New{{$t.Name}}Pipe(out)
+}
+
+func (p *{{$t.Name}}Pipe) Reverse() *{{$t.Name}}Pipe {
+	out := p.ToSlice()
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return New{{$t.Name}}Pipe(out)
+}
+{{if $.IsKey $t}}
+func (p *{{$t.Name}}Pipe) Uniq() *{{$t.Name}}Pipe {
+	out := make([]{{$t.Type}}, 0, p.n)
+	exists := make(map[{{$t.Type}}]bool)
+	for i := 0; i < p.n; i++ {
+		if v, ok := p.get(i); ok && !exists[v] {
+			exists[v] = true
+			out = append(out, v)
+		}
+	}
+	return New{{$t.Name}}Pipe(out)
+}
+{{end}}{{end}}`
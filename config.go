@@ -0,0 +1,117 @@
+package pipe
+
+import (
+	"time"
+)
+
+// _Config holds the settings of a pipe that are not stages. It is attached
+// to a pipe node by the With* methods and inherited by every pipe built on
+// top of it.
+type _Config struct {
+	window   int
+	executor Executor
+	clock    Clock
+	// inFlight, when not nil, holds a slot for every element being
+	// processed by a P* terminal
+	inFlight chan struct{}
+	deadline time.Time
+	// deadLetters, when not nil, receives the elements stages fail on
+	deadLetters *DeadLetters
+	hooks       []Hook
+	progress    func(done, total int)
+	// noTaps leaves the Tap stages out of the plan
+	noTaps bool
+}
+
+var defaultConfig = &_Config{
+	window:   1024,
+	executor: GoExecutor,
+	clock:    realClock{},
+}
+
+// _IConfigurable is implemented by procs depending on the settings of the
+// pipe they run in. configure returns the proc bound to conf, used by the
+// compiled plan.
+type _IConfigurable interface {
+	configure(conf *_Config) _IProc
+}
+
+// Clock is the source of time of time-based stages such as Throttle.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (p *_Pipe) config() *_Config {
+	for pp := p; pp != nil; {
+		if pp.conf != nil {
+			return pp.conf
+		}
+		if pp.srcPipe != nil {
+			pp = pp.srcPipe
+		} else {
+			pp = pp.origin
+		}
+	}
+	return defaultConfig
+}
+
+func (p *_Pipe) withConfig(change func(*_Config)) *_Pipe {
+	conf := *p.config()
+	change(&conf)
+	return &_Pipe{
+		srcPipe: p,
+		err:     p.err,
+		conf:    &conf,
+	}
+}
+
+// WithWindow limits how many elements a P* terminal has in flight at once.
+// Ordered terminals never buffer more than n finished elements waiting for
+// an earlier one.
+func (p *_Pipe) WithWindow(n int) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.window = n
+	})
+}
+
+// WithClock makes the time-based stages of the pipe use c instead of the
+// real time.
+func (p *_Pipe) WithClock(c Clock) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.clock = c
+	})
+}
+
+// MaxInFlight limits how many elements the P* terminals of the pipe
+// process at once, across all their workers. For PEach and its variants
+// the call of fn counts as processing too.
+func (p *_Pipe) MaxInFlight(n int) *_Pipe {
+	if n < 1 {
+		n = 1
+	}
+	return p.withConfig(func(conf *_Config) {
+		conf.inFlight = make(chan struct{}, n)
+	})
+}
+
+// limit runs fn holding an in-flight slot if the pipe has a limit.
+func (conf *_Config) limit(fn func()) {
+	if conf.inFlight != nil {
+		conf.inFlight <- struct{}{}
+		defer func() {
+			<-conf.inFlight
+		}()
+	}
+	fn()
+}
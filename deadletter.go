@@ -0,0 +1,211 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+)
+
+// DeadLetter is an element that a stage failed on.
+type DeadLetter struct {
+	Value interface{}
+	// Index is the source index of the element.
+	Index int
+	// Stage names the failing stage as listed by Explain.
+	Stage string
+	// Err is the error given by Require, or a *PanicError for a stage
+	// that panicked.
+	Err error
+}
+
+// StageSummary counts the elements a stage left out: Dropped ones were
+// filtered out, Failed ones were sent to the dead letters.
+type StageSummary struct {
+	Stage   string
+	Dropped int
+	Failed  int
+}
+
+// DeadLetters collects the elements the stages of a pipe fail on, so that
+// the terminal can go on with the others, and counts the elements each
+// stage leaves out.
+type DeadLetters struct {
+	send func(DeadLetter)
+
+	mu      sync.Mutex
+	order   []string
+	summary map[string]*StageSummary
+}
+
+// NewDeadLetters returns DeadLetters handing every failed element to out,
+// which is a *[]DeadLetter to append to, a chan DeadLetter to send to or a
+// func(DeadLetter) to call. Sending blocks the terminal until the channel
+// is read; the function may be called concurrently.
+func NewDeadLetters(out interface{}) *DeadLetters {
+	d := &DeadLetters{summary: make(map[string]*StageSummary)}
+	switch out := out.(type) {
+	case *[]DeadLetter:
+		var mu sync.Mutex
+		d.send = func(letter DeadLetter) {
+			mu.Lock()
+			*out = append(*out, letter)
+			mu.Unlock()
+		}
+	case chan DeadLetter:
+		d.send = func(letter DeadLetter) {
+			out <- letter
+		}
+	case chan<- DeadLetter:
+		d.send = func(letter DeadLetter) {
+			out <- letter
+		}
+	case func(DeadLetter):
+		d.send = out
+	default:
+		panic(fmt.Sprintf("pipe: cannot send dead letters to %T", out))
+	}
+	return d
+}
+
+func (d *DeadLetters) stage(name string) *StageSummary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.summary[name]
+	if !ok {
+		s = &StageSummary{Stage: name}
+		d.summary[name] = s
+		d.order = append(d.order, name)
+	}
+	return s
+}
+
+func (d *DeadLetters) count(s *StageSummary, failed bool) {
+	d.mu.Lock()
+	if failed {
+		s.Failed++
+	} else {
+		s.Dropped++
+	}
+	d.mu.Unlock()
+}
+
+// Summary returns the counts of every stage that ran with d, in the order
+// the stages were first compiled.
+func (d *DeadLetters) Summary() []StageSummary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	summary := make([]StageSummary, len(d.order))
+	for i, name := range d.order {
+		summary[i] = *d.summary[name]
+	}
+	return summary
+}
+
+// WithDeadLetters makes the stages of the pipe send the elements they
+// fail on to d instead of stopping the terminal. The stages are then
+// checked one by one instead of being fused.
+func (p *_Pipe) WithDeadLetters(d *DeadLetters) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.deadLetters = d
+	})
+}
+
+// _IIndexedProc is implemented by procs that need the source index of the
+// element they process.
+type _IIndexedProc interface {
+	nextAt(index int, input reflect.Value) (reflect.Value, bool)
+}
+
+func procNext(proc _IProc, index int, input reflect.Value) (reflect.Value, bool) {
+	if p, ok := proc.(_IIndexedProc); ok {
+		return p.nextAt(index, input)
+	}
+	return proc.Next(input)
+}
+
+// _GuardProc turns the failures of its inner proc into dead letters and
+// counts the elements it leaves out.
+type _GuardProc struct {
+	inner   _IProc
+	dead    *DeadLetters
+	summary *StageSummary
+	// obs, if the plan is observed, is told about the failures
+	obs *_Observer
+}
+
+func (g *_GuardProc) nextAt(index int, input reflect.Value) (output reflect.Value, keep bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			g.fail(index, input, &PanicError{Index: index, Value: r, Stack: debug.Stack()})
+			output, keep = input, false
+		}
+	}()
+	if r, ok := g.inner.(*_RequireProc); ok {
+		if err := r.check(input); err != nil {
+			g.fail(index, input, err)
+			return input, false
+		}
+		return input, true
+	}
+	output, keep = procNext(g.inner, index, input)
+	if !keep {
+		g.dead.count(g.summary, false)
+	}
+	return output, keep
+}
+
+func (g *_GuardProc) fail(index int, input reflect.Value, err error) {
+	letter := DeadLetter{Value: input.Interface(), Index: index, Stage: g.summary.Stage, Err: err}
+	g.dead.count(g.summary, true)
+	if g.obs != nil {
+		g.obs.deadLetter(letter)
+	}
+	g.dead.send(letter)
+}
+
+func (g *_GuardProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return g.nextAt(-1, input)
+}
+
+func (g *_GuardProc) GetOutType() reflect.Type {
+	return g.inner.GetOutType()
+}
+
+// guardProcs wraps every proc of chain, the last stages of p, in a
+// _GuardProc sending to d.
+func (p *_Pipe) guardProcs(chain []_IProc, d *DeadLetters) {
+	p.wrapProcs(chain, func(i int, name string, inner _IProc) _IProc {
+		return &_GuardProc{inner: inner, dead: d, summary: d.stage(name)}
+	})
+}
+
+type _RequireProc struct {
+	Type reflect.Type
+	Func reflect.Value
+}
+
+func (r *_RequireProc) check(input reflect.Value) error {
+	err, _ := r.Func.Call([]reflect.Value{input})[0].Interface().(error)
+	return err
+}
+
+func (r *_RequireProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return input, r.check(input) == nil
+}
+
+func (r *_RequireProc) GetOutType() reflect.Type {
+	return r.Type
+}
+
+func (r *_RequireProc) describe() _StageDesc {
+	return _StageDesc{Kind: "require", InType: r.Type, OutType: r.Type, Func: r.Func}
+}
+
+// Require keeps the elements for which fn returns nil. The others are
+// dropped, or sent to the dead letters of the pipe with the error.
+func (p *_Pipe) Require(fn interface{}) *_Pipe {
+	return p.addStage("require", fn, []interface{}{p.getOutType()}, []interface{}{errorType}, func() _IProc {
+		return &_RequireProc{Type: p.getOutType(), Func: reflect.ValueOf(fn)}
+	})
+}
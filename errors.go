@@ -0,0 +1,118 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PipeError reports a function whose signature does not fit the pipe it is
+// added to. Stage is the index of the stage as listed by Explain; for a
+// terminal it is the number of stages before it.
+type PipeError struct {
+	Stage    int
+	Kind     string
+	Expected string
+	Actual   string
+}
+
+func (e *PipeError) Error() string {
+	return fmt.Sprintf("pipe: invalid %s function at stage #%d: want %s, got %s", e.Kind, e.Stage, e.Expected, e.Actual)
+}
+
+func formatArgs(args []interface{}) string {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		switch t := arg.(type) {
+		case reflect.Type:
+			names[i] = t.String()
+		case reflect.Kind:
+			names[i] = t.String()
+		default:
+			names[i] = "?"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// formatSignature renders the expectations passed to isGoodFunc as a
+// function signature, with ? for parameters that may be of any type.
+func formatSignature(intypes, outtypes []interface{}) string {
+	s := "func(" + formatArgs(intypes) + ")"
+	switch len(outtypes) {
+	case 0:
+		return s
+	case 1:
+		return s + " " + formatArgs(outtypes)
+	default:
+		return s + " (" + formatArgs(outtypes) + ")"
+	}
+}
+
+func (p *_Pipe) stageCount() int {
+	_, stages := p.stages()
+	return len(stages)
+}
+
+func (p *_Pipe) checkFunc(kind string, f interface{}, intypes, outtypes []interface{}) *PipeError {
+	fType := reflect.TypeOf(f)
+	if fType != nil && isGoodFunc(fType, intypes, outtypes) {
+		return nil
+	}
+	actual := "nil"
+	if fType != nil {
+		actual = fType.String()
+	}
+	return &PipeError{
+		Stage:    p.stageCount(),
+		Kind:     kind,
+		Expected: formatSignature(intypes, outtypes),
+		Actual:   actual,
+	}
+}
+
+// mustFunc is checkFunc for terminals, which have no pipe to carry the
+// error and panic with it instead.
+func (p *_Pipe) mustFunc(kind string, f interface{}, intypes, outtypes []interface{}) {
+	if err := p.checkFunc(kind, f, intypes, outtypes); err != nil {
+		panic(err)
+	}
+}
+
+func (p *_Pipe) mustValid() {
+	if p.err != nil {
+		panic(p.err)
+	}
+}
+
+func (p *_Pipe) withError(err *PipeError) *_Pipe {
+	return &_Pipe{
+		srcPipe: p,
+		err:     err,
+	}
+}
+
+// addStage validates f against the output type of p and appends the proc
+// built by newProc. An invalid stage does not panic; the error is carried
+// by the returned pipe and reported by Validate or by its terminal.
+func (p *_Pipe) addStage(kind string, f interface{}, intypes, outtypes []interface{}, newProc func() _IProc) *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
+	if err := p.checkFunc(kind, f, intypes, outtypes); err != nil {
+		return p.withError(err)
+	}
+	return &_Pipe{
+		srcPipe: p,
+		proc:    newProc(),
+	}
+}
+
+// Validate checks the signature of every stage of the pipe without running
+// it and returns the first *PipeError found.
+func (p *_Pipe) Validate() error {
+	if p.err != nil {
+		return p.err
+	}
+	return nil
+}
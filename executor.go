@@ -0,0 +1,128 @@
+package pipe
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Executor decides where the work of P* terminals runs. Submit must not
+// block waiting for previously submitted work to finish, since a task may
+// itself submit more work.
+type Executor interface {
+	Submit(task func())
+}
+
+// BatchExecutor is an Executor that only runs submitted tasks when Flush is
+// called. P* terminals flush it whenever they would otherwise wait for
+// their own tasks, so it fully controls the order the tasks run in. Flush
+// must run every queued task, including the ones submitted meanwhile.
+type BatchExecutor interface {
+	Executor
+	Flush()
+}
+
+type _GoExecutor struct{}
+
+func (_GoExecutor) Submit(task func()) {
+	go task()
+}
+
+type _InlineExecutor struct{}
+
+func (_InlineExecutor) Submit(task func()) {
+	task()
+}
+
+var (
+	// GoExecutor runs every task on a new goroutine. It is the default.
+	GoExecutor Executor = _GoExecutor{}
+	// InlineExecutor runs every task synchronously inside Submit, which
+	// makes P* terminals deterministic and sequential.
+	InlineExecutor Executor = _InlineExecutor{}
+)
+
+// PoolExecutor runs tasks on a fixed number of goroutines. Tasks submitted
+// while all of them are busy are queued.
+type PoolExecutor struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []func()
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewPoolExecutor starts a pool of n goroutines.
+func NewPoolExecutor(n int) *PoolExecutor {
+	if n < 1 {
+		n = 1
+	}
+	e := &PoolExecutor{}
+	e.cond = sync.NewCond(&e.mu)
+	e.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go e.work()
+	}
+	return e
+}
+
+func (e *PoolExecutor) work() {
+	defer e.wg.Done()
+	for {
+		e.mu.Lock()
+		for len(e.queue) == 0 && !e.closed {
+			e.cond.Wait()
+		}
+		if len(e.queue) == 0 {
+			e.mu.Unlock()
+			return
+		}
+		task := e.queue[0]
+		e.queue[0] = nil
+		e.queue = e.queue[1:]
+		e.mu.Unlock()
+		task()
+	}
+}
+
+// Submit queues task. It panics if the pool has been closed.
+func (e *PoolExecutor) Submit(task func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		panic("pipe: submit to a closed PoolExecutor")
+	}
+	e.queue = append(e.queue, task)
+	e.cond.Signal()
+}
+
+// Close lets the queued tasks finish and stops the goroutines of the pool.
+func (e *PoolExecutor) Close() {
+	e.mu.Lock()
+	e.closed = true
+	e.cond.Broadcast()
+	e.mu.Unlock()
+	e.wg.Wait()
+}
+
+var (
+	sharedExecutor     *PoolExecutor
+	sharedExecutorOnce sync.Once
+)
+
+// SharedExecutor returns a process-wide pool with one goroutine per CPU,
+// so that pipes running at the same time do not oversubscribe the CPU.
+// Functions running on it must not themselves wait for a P* terminal using
+// the same pool.
+func SharedExecutor() Executor {
+	sharedExecutorOnce.Do(func() {
+		sharedExecutor = NewPoolExecutor(runtime.GOMAXPROCS(0))
+	})
+	return sharedExecutor
+}
+
+// WithExecutor makes the P* terminals of the pipe run their work on e.
+func (p *_Pipe) WithExecutor(e Executor) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.executor = e
+	})
+}
@@ -0,0 +1,137 @@
+package pipe
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+type _StageDesc struct {
+	Kind    string
+	InType  reflect.Type
+	OutType reflect.Type
+	Func    reflect.Value
+}
+
+// _IDescribable is implemented by procs that can describe themselves in
+// Explain and in error messages.
+type _IDescribable interface {
+	describe() _StageDesc
+}
+
+func (m *_MapProc) describe() _StageDesc {
+	return _StageDesc{Kind: "map", InType: m.InType, OutType: m.OutType, Func: m.Func}
+}
+
+func (f *_FilterProc) describe() _StageDesc {
+	return _StageDesc{Kind: "filter", InType: f.InType, OutType: f.OutType, Func: f.Func}
+}
+
+func describeProc(proc _IProc) _StageDesc {
+	if d, ok := proc.(_IDescribable); ok {
+		return d.describe()
+	}
+	return _StageDesc{Kind: "proc", OutType: proc.GetOutType()}
+}
+
+func funcName(f reflect.Value) string {
+	if !f.IsValid() || f.Kind() != reflect.Func {
+		return ""
+	}
+	if rf := runtime.FuncForPC(f.Pointer()); rf != nil {
+		return rf.Name()
+	}
+	return ""
+}
+
+func (d _StageDesc) String() string {
+	s := fmt.Sprintf("%s %v -> %v", d.Kind, d.InType, d.OutType)
+	if d.Kind == "map" && !d.Func.IsValid() {
+		s += " (identity)"
+	} else if name := funcName(d.Func); name != "" {
+		s += " " + name
+	}
+	return s
+}
+
+// stages returns the root pipe holding the original source and every stage
+// between it and p, including the ones consumed by Sort, Uniq and Reverse.
+func (p *_Pipe) stages() (*_Pipe, []_StageDesc) {
+	var procs []_IProc
+	pp := p
+	for ; pp.srcPipe != nil; pp = pp.srcPipe {
+		if pp.proc != nil {
+			procs = append(procs, pp.proc)
+		}
+	}
+	root := pp
+	var stages []_StageDesc
+	if pp.origin != nil {
+		root, stages = pp.origin.stages()
+		stages = append(stages, *pp.originStage)
+	}
+	for i := len(procs) - 1; i >= 0; i-- {
+		stages = append(stages, describeProc(procs[i]))
+	}
+	return root, stages
+}
+
+func (p *_Pipe) describeSource() string {
+	if p.srcDesc != "" {
+		return p.srcDesc
+	}
+	if r, ok := p.arr.(*_Range); ok {
+		return fmt.Sprintf("Range(begin=%d, end=%d, step=%d) len %d", r.begin, r.end, r.step, p.srcLen())
+	}
+	return fmt.Sprintf("%T len %d", p.arr, reflect.ValueOf(p.arr).Len())
+}
+
+// Explain returns a human readable description of the source and every
+// stage of the pipe, in execution order.
+func (p *_Pipe) Explain() string {
+	root, stages := p.stages()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "source: %s\n", root.describeSource())
+	for i, stage := range stages {
+		fmt.Fprintf(&buf, "  #%d %s\n", i, stage)
+	}
+	if p.err != nil {
+		fmt.Fprintf(&buf, "error: %v\n", p.err)
+	}
+	return buf.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return strings.Replace(s, `"`, `\"`, -1)
+}
+
+// ExplainDOT renders the stage chain of the pipe as a Graphviz digraph.
+func (p *_Pipe) ExplainDOT() string {
+	root, stages := p.stages()
+	var buf bytes.Buffer
+	buf.WriteString("digraph pipe {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	fmt.Fprintf(&buf, "\tsource [label=\"%s\"];\n", dotQuote(root.describeSource()))
+	prev := "source"
+	for i, stage := range stages {
+		lines := []string{
+			fmt.Sprintf("#%d %s", i, stage.Kind),
+			fmt.Sprintf("%v -> %v", stage.InType, stage.OutType),
+		}
+		if name := funcName(stage.Func); name != "" {
+			lines = append(lines, name)
+		}
+		for j := range lines {
+			lines[j] = dotQuote(lines[j])
+		}
+		label := strings.Join(lines, `\n`)
+		node := fmt.Sprintf("stage%d", i)
+		fmt.Fprintf(&buf, "\t%s [label=\"%s\"];\n", node, label)
+		fmt.Fprintf(&buf, "\t%s -> %s;\n", prev, node)
+		prev = node
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
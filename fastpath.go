@@ -0,0 +1,153 @@
+package pipe
+
+import (
+	"reflect"
+)
+
+var (
+	intType     = reflect.TypeOf(int(0))
+	int64Type   = reflect.TypeOf(int64(0))
+	float64Type = reflect.TypeOf(float64(0))
+	stringType  = reflect.TypeOf("")
+	boolType    = reflect.TypeOf(false)
+	ifaceType   = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+func valueOfIface(v interface{}) reflect.Value {
+	if v == nil {
+		return reflect.Zero(ifaceType)
+	}
+	return reflect.ValueOf(v)
+}
+
+// fastMapStep returns a call path for common map function signatures that
+// calls the function directly instead of going through reflect.Value.Call.
+// It returns nil when the signature has no fast path.
+func fastMapStep(f interface{}) _StepFunc {
+	switch fn := f.(type) {
+	case func(int) int:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(int(in.Int()))), true
+		}
+	case func(int) string:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(int(in.Int()))), true
+		}
+	case func(int) bool:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(int(in.Int()))), true
+		}
+	case func(int) float64:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(int(in.Int()))), true
+		}
+	case func(int64) int64:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(in.Int())), true
+		}
+	case func(float64) float64:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(in.Float())), true
+		}
+	case func(string) string:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(in.String())), true
+		}
+	case func(string) int:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return reflect.ValueOf(fn(in.String())), true
+		}
+	case func(interface{}) interface{}:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return valueOfIface(fn(in.Interface())), true
+		}
+	}
+	return nil
+}
+
+// fastFilterStep is the filter counterpart of fastMapStep.
+func fastFilterStep(f interface{}) _StepFunc {
+	switch fn := f.(type) {
+	case func(int) bool:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return in, fn(int(in.Int()))
+		}
+	case func(int64) bool:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return in, fn(in.Int())
+		}
+	case func(float64) bool:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return in, fn(in.Float())
+		}
+	case func(string) bool:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return in, fn(in.String())
+		}
+	case func(interface{}) bool:
+		return func(in reflect.Value) (reflect.Value, bool) {
+			return in, fn(in.Interface())
+		}
+	}
+	return nil
+}
+
+// _SliceBuilder collects output values into a slice. The typed builders
+// append to a plain Go slice so that no reflect.Append is needed.
+type _SliceBuilder interface {
+	Append(reflect.Value)
+	Result() interface{}
+}
+
+func newSliceBuilder(elemType reflect.Type, capacity int) _SliceBuilder {
+	switch elemType {
+	case intType:
+		return &_IntSliceBuilder{make([]int, 0, capacity)}
+	case int64Type:
+		return &_Int64SliceBuilder{make([]int64, 0, capacity)}
+	case float64Type:
+		return &_Float64SliceBuilder{make([]float64, 0, capacity)}
+	case stringType:
+		return &_StringSliceBuilder{make([]string, 0, capacity)}
+	case boolType:
+		return &_BoolSliceBuilder{make([]bool, 0, capacity)}
+	case ifaceType:
+		return &_IfaceSliceBuilder{make([]interface{}, 0, capacity)}
+	}
+	return &_ReflectSliceBuilder{reflect.MakeSlice(reflect.SliceOf(elemType), 0, capacity)}
+}
+
+type _IntSliceBuilder struct{ out []int }
+
+func (b *_IntSliceBuilder) Append(v reflect.Value) { b.out = append(b.out, int(v.Int())) }
+func (b *_IntSliceBuilder) Result() interface{}    { return b.out }
+
+type _Int64SliceBuilder struct{ out []int64 }
+
+func (b *_Int64SliceBuilder) Append(v reflect.Value) { b.out = append(b.out, v.Int()) }
+func (b *_Int64SliceBuilder) Result() interface{}    { return b.out }
+
+type _Float64SliceBuilder struct{ out []float64 }
+
+func (b *_Float64SliceBuilder) Append(v reflect.Value) { b.out = append(b.out, v.Float()) }
+func (b *_Float64SliceBuilder) Result() interface{}    { return b.out }
+
+type _StringSliceBuilder struct{ out []string }
+
+func (b *_StringSliceBuilder) Append(v reflect.Value) { b.out = append(b.out, v.String()) }
+func (b *_StringSliceBuilder) Result() interface{}    { return b.out }
+
+type _BoolSliceBuilder struct{ out []bool }
+
+func (b *_BoolSliceBuilder) Append(v reflect.Value) { b.out = append(b.out, v.Bool()) }
+func (b *_BoolSliceBuilder) Result() interface{}    { return b.out }
+
+type _IfaceSliceBuilder struct{ out []interface{} }
+
+func (b *_IfaceSliceBuilder) Append(v reflect.Value) { b.out = append(b.out, v.Interface()) }
+func (b *_IfaceSliceBuilder) Result() interface{}    { return b.out }
+
+type _ReflectSliceBuilder struct{ out reflect.Value }
+
+func (b *_ReflectSliceBuilder) Append(v reflect.Value) { b.out = reflect.Append(b.out, v) }
+func (b *_ReflectSliceBuilder) Result() interface{}    { return b.out.Interface() }
@@ -0,0 +1,97 @@
+package pipe
+
+import (
+	"reflect"
+)
+
+// Indexed is an element paired with its source index by WithIndex.
+type Indexed struct {
+	Index int
+	Value interface{}
+}
+
+var indexedType = reflect.TypeOf(Indexed{})
+
+// _IndexedMapProc is a map or filter whose function also takes the source
+// index of the element.
+type _IndexedMapProc struct {
+	InType  reflect.Type
+	OutType reflect.Type
+	Func    reflect.Value
+	filter  bool
+}
+
+func (m *_IndexedMapProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	outs := m.Func.Call([]reflect.Value{input, reflect.ValueOf(index)})
+	if m.filter {
+		return input, outs[0].Bool()
+	}
+	return outs[0], true
+}
+
+func (m *_IndexedMapProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return m.nextAt(-1, input)
+}
+
+func (m *_IndexedMapProc) GetOutType() reflect.Type {
+	return m.OutType
+}
+
+func (m *_IndexedMapProc) describe() _StageDesc {
+	kind := "mapindexed"
+	if m.filter {
+		kind = "filterindexed"
+	}
+	return _StageDesc{Kind: kind, InType: m.InType, OutType: m.OutType, Func: m.Func}
+}
+
+// _WithIndexProc pairs every element with its source index.
+type _WithIndexProc struct {
+	InType reflect.Type
+}
+
+func (w *_WithIndexProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	return reflect.ValueOf(Indexed{Index: index, Value: input.Interface()}), true
+}
+
+func (w *_WithIndexProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return w.nextAt(-1, input)
+}
+
+func (w *_WithIndexProc) GetOutType() reflect.Type {
+	return indexedType
+}
+
+func (w *_WithIndexProc) describe() _StageDesc {
+	return _StageDesc{Kind: "withindex", InType: w.InType, OutType: indexedType}
+}
+
+// MapIndexed is Map for a func(T, int) U also given the source index of
+// the element, its position in the slice the pipe reads, which stays the
+// same through the stages before it.
+func (p *_Pipe) MapIndexed(fn interface{}) *_Pipe {
+	return p.addStage("mapindexed", fn, []interface{}{p.getOutType(), reflect.Int}, []interface{}{nil}, func() _IProc {
+		fType := reflect.TypeOf(fn)
+		return &_IndexedMapProc{InType: p.getOutType(), OutType: fType.Out(0), Func: reflect.ValueOf(fn)}
+	})
+}
+
+// FilterIndexed is Filter for a func(T, int) bool also given the source
+// index of the element, as in MapIndexed.
+func (p *_Pipe) FilterIndexed(fn interface{}) *_Pipe {
+	return p.addStage("filterindexed", fn, []interface{}{p.getOutType(), reflect.Int}, []interface{}{reflect.Bool}, func() _IProc {
+		return &_IndexedMapProc{InType: p.getOutType(), OutType: p.getOutType(), Func: reflect.ValueOf(fn), filter: true}
+	})
+}
+
+// WithIndex turns every element into an Indexed holding it and its source
+// index, as in MapIndexed.
+func (p *_Pipe) WithIndex() *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
+	return &_Pipe{
+		srcPipe: p,
+		proc:    &_WithIndexProc{InType: p.getOutType()},
+	}
+}
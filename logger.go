@@ -0,0 +1,98 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxLoggedValue is the length element values are truncated to in logs.
+const maxLoggedValue = 64
+
+func truncateValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if r := []rune(s); len(r) > maxLoggedValue {
+		return string(r[:maxLoggedValue]) + "..."
+	}
+	return s
+}
+
+// _LogHook logs the run of a pipe to a slog.Logger: terminals at level,
+// elements at debug level and failures at error level.
+type _LogHook struct {
+	logger *slog.Logger
+	level  slog.Level
+	source string
+	stages []string
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+func (h *_LogHook) bind(p *_Pipe) Hook {
+	root, stages := p.stages()
+	bound := &_LogHook{logger: h.logger, level: h.level, source: root.describeSource()}
+	for i, stage := range stages {
+		bound.stages = append(bound.stages, fmt.Sprintf("#%d %v", i, stage))
+	}
+	return bound
+}
+
+func (h *_LogHook) OnStart(total int) {
+	h.mu.Lock()
+	h.start = time.Now()
+	h.mu.Unlock()
+	h.logger.Log(context.Background(), h.level, "pipe start", "source", h.source, "stages", h.stages, "total", total)
+}
+
+func (h *_LogHook) OnItem(stage string, in, out interface{}, d time.Duration) {
+	h.onItemAt(-1, stage, in, out, d)
+}
+
+func (h *_LogHook) OnDrop(stage string, in interface{}, d time.Duration) {
+	h.onDropAt(-1, stage, in, d)
+}
+
+func (h *_LogHook) onItemAt(index int, stage string, in, out interface{}, d time.Duration) {
+	ctx := context.Background()
+	if !h.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	h.logger.Log(ctx, slog.LevelDebug, "pipe item", "index", index, "stage", stage,
+		"in", truncateValue(in), "out", truncateValue(out), "duration", d)
+}
+
+func (h *_LogHook) onDropAt(index int, stage string, in interface{}, d time.Duration) {
+	ctx := context.Background()
+	if !h.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	h.logger.Log(ctx, slog.LevelDebug, "pipe drop", "index", index, "stage", stage,
+		"in", truncateValue(in), "duration", d)
+}
+
+func (h *_LogHook) onDeadLetter(letter DeadLetter) {
+	h.logger.Log(context.Background(), slog.LevelError, "pipe element failed", "index", letter.Index,
+		"stage", letter.Stage, "in", truncateValue(letter.Value), "error", letter.Err)
+}
+
+func (h *_LogHook) OnFinish(err error) {
+	h.mu.Lock()
+	d := time.Since(h.start)
+	h.mu.Unlock()
+	if err != nil {
+		h.logger.Log(context.Background(), slog.LevelError, "pipe failed", "duration", d, "error", err)
+		return
+	}
+	h.logger.Log(context.Background(), h.level, "pipe finish", "duration", d)
+}
+
+// WithLogger logs the terminals of the pipe to logger: their start, with
+// the stages as listed by Explain, and their end at level, every element
+// going through a stage at debug level and the panics recovered from the
+// stages at error level. Like WithHook it stops the stages being fused.
+func (p *_Pipe) WithLogger(logger *slog.Logger, level slog.Level) *_Pipe {
+	return p.WithHook(&_LogHook{logger: logger, level: level})
+}
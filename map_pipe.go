@@ -1,6 +1,7 @@
 package pipe
 
 import (
+	"fmt"
 	"reflect"
 )
 
@@ -32,7 +33,9 @@ func (mp *_MapPipe) Keys() *_Pipe {
 	for _, keyValue := range keysValue {
 		newSliceValue = reflect.Append(newSliceValue, keyValue)
 	}
-	return NewPipe(newSliceValue.Interface())
+	p := NewPipe(newSliceValue.Interface())
+	p.srcDesc = fmt.Sprintf("keys of %v len %d", mp.t, length)
+	return p
 }
 
 func (mp *_MapPipe) Values() *_Pipe {
@@ -44,5 +47,7 @@ func (mp *_MapPipe) Values() *_Pipe {
 	for _, keyValue := range keysValue {
 		newSliceValue = reflect.Append(newSliceValue, mp.v.MapIndex(keyValue))
 	}
-	return NewPipe(newSliceValue.Interface())
+	p := NewPipe(newSliceValue.Interface())
+	p.srcDesc = fmt.Sprintf("values of %v len %d", mp.t, length)
+	return p
 }
@@ -0,0 +1,241 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PipelineMetrics are the numbers Metrics gathered for a named pipeline.
+// Errors counts the elements sent to dead letters and the terminals that
+// failed; InFlight the elements inside the stages and RunsInFlight the
+// running terminals when the snapshot was taken.
+type PipelineMetrics struct {
+	Name         string
+	Runs         int
+	RunsInFlight int
+	In           int
+	Out          int
+	Dropped      int
+	Errors       int
+	InFlight     int
+	Stages       []StageStats
+}
+
+type _PipelineCounters struct {
+	runs, runsInFlight int
+	in, out, left      int
+	inFlight           int
+	failed, failedRuns int
+	stages             *StatsCollector
+}
+
+// Metrics is a registry of the metrics of named pipelines, fed by
+// WithMetrics. It is an expvar.Var, so it can be published with
+// expvar.Publish, and Handler serves it in the Prometheus text format.
+type Metrics struct {
+	mu        sync.Mutex
+	pipelines map[string]*_PipelineCounters
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{pipelines: make(map[string]*_PipelineCounters)}
+}
+
+func (m *Metrics) pipeline(name string) *_PipelineCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.pipelines[name]
+	if !ok {
+		c = &_PipelineCounters{stages: NewStatsCollector()}
+		m.pipelines[name] = c
+	}
+	return c
+}
+
+func (m *Metrics) update(fn func()) {
+	m.mu.Lock()
+	fn()
+	m.mu.Unlock()
+}
+
+// Pipelines returns the metrics of every pipeline sorted by name.
+func (m *Metrics) Pipelines() []PipelineMetrics {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.pipelines))
+	for name := range m.pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pipelines := make([]PipelineMetrics, len(names))
+	counters := make([]*_PipelineCounters, len(names))
+	for i, name := range names {
+		c := m.pipelines[name]
+		counters[i] = c
+		pipelines[i] = PipelineMetrics{
+			Name:         name,
+			Runs:         c.runs,
+			RunsInFlight: c.runsInFlight,
+			In:           c.in,
+			Out:          c.out,
+			Dropped:      c.left - c.failed,
+			Errors:       c.failed + c.failedRuns,
+			InFlight:     c.inFlight,
+		}
+	}
+	m.mu.Unlock()
+	for i, c := range counters {
+		pipelines[i].Stages = c.stages.Stages()
+	}
+	return pipelines
+}
+
+// String renders the metrics as a JSON object keyed by pipeline name, as
+// expvar expects.
+func (m *Metrics) String() string {
+	obj := make(map[string]PipelineMetrics)
+	for _, p := range m.Pipelines() {
+		obj[p.Name] = p
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Sprintf("%q", err.Error())
+	}
+	return string(b)
+}
+
+// promEscape escapes a Prometheus label value.
+var promEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the metrics to buf in the Prometheus text format.
+func (m *Metrics) WritePrometheus(buf *bytes.Buffer) {
+	pipelines := m.Pipelines()
+	metric := func(name, kind, help string, value func(p PipelineMetrics) int) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, p := range pipelines {
+			fmt.Fprintf(buf, "%s{pipeline=\"%s\"} %d\n", name, promEscape.Replace(p.Name), value(p))
+		}
+	}
+	metric("pipe_runs_total", "counter", "Terminals started.", func(p PipelineMetrics) int { return p.Runs })
+	metric("pipe_runs_in_flight", "gauge", "Terminals running.", func(p PipelineMetrics) int { return p.RunsInFlight })
+	metric("pipe_items_in_total", "counter", "Source elements that entered the stages.", func(p PipelineMetrics) int { return p.In })
+	metric("pipe_items_out_total", "counter", "Elements that went through the stages.", func(p PipelineMetrics) int { return p.Out })
+	metric("pipe_items_dropped_total", "counter", "Elements left out by the stages.", func(p PipelineMetrics) int { return p.Dropped })
+	metric("pipe_errors_total", "counter", "Elements sent to dead letters and failed terminals.", func(p PipelineMetrics) int { return p.Errors })
+	metric("pipe_items_in_flight", "gauge", "Elements inside the stages.", func(p PipelineMetrics) int { return p.InFlight })
+
+	name := "pipe_stage_duration_seconds"
+	fmt.Fprintf(buf, "# HELP %s Time spent in a stage per element.\n# TYPE %s histogram\n", name, name)
+	for _, p := range pipelines {
+		for _, s := range p.Stages {
+			labels := fmt.Sprintf("pipeline=\"%s\",stage=\"%s\"", promEscape.Replace(p.Name), promEscape.Replace(s.Stage))
+			count := 0
+			for i, bound := range HistogramBounds {
+				count += s.Latency[i]
+				fmt.Fprintf(buf, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound.Seconds(), count)
+			}
+			fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, s.In)
+			fmt.Fprintf(buf, "%s_sum{%s} %g\n", name, labels, s.Total.Seconds())
+			fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, s.In)
+		}
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		m.WritePrometheus(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// _MetricsHook feeds the counters of a pipeline of a Metrics. runs and
+// pending are the running terminals of the hook and their elements inside
+// the stages, which the counters forget once the last of them returned.
+type _MetricsHook struct {
+	m *Metrics
+	c *_PipelineCounters
+
+	runs    int
+	pending int
+}
+
+func (h *_MetricsHook) OnStart(total int) {
+	h.m.update(func() {
+		h.c.runs++
+		h.c.runsInFlight++
+		h.runs++
+	})
+}
+
+func (h *_MetricsHook) OnItem(stage string, in, out interface{}, d time.Duration) {
+	h.c.stages.OnItem(stage, in, out, d)
+}
+
+func (h *_MetricsHook) OnDrop(stage string, in interface{}, d time.Duration) {
+	h.c.stages.OnDrop(stage, in, d)
+}
+
+func (h *_MetricsHook) onItemAt(index int, stage string, in, out interface{}, d time.Duration) {
+	h.OnItem(stage, in, out, d)
+}
+
+func (h *_MetricsHook) onDropAt(index int, stage string, in interface{}, d time.Duration) {
+	h.OnDrop(stage, in, d)
+}
+
+func (h *_MetricsHook) onDeadLetter(letter DeadLetter) {
+	h.m.update(func() {
+		h.c.failed++
+	})
+}
+
+func (h *_MetricsHook) elementStart(index int) {
+	h.m.update(func() {
+		h.c.in++
+		h.c.inFlight++
+		h.pending++
+	})
+}
+
+func (h *_MetricsHook) elementDone(index int, kept bool) {
+	h.m.update(func() {
+		if kept {
+			h.c.out++
+		} else {
+			h.c.left++
+		}
+		if h.pending > 0 {
+			h.c.inFlight--
+			h.pending--
+		}
+	})
+}
+
+func (h *_MetricsHook) OnFinish(err error) {
+	h.m.update(func() {
+		h.c.runsInFlight--
+		if err != nil {
+			h.c.failedRuns++
+		}
+		// elements a failed terminal left inside the stages
+		if h.runs--; h.runs == 0 {
+			h.c.inFlight -= h.pending
+			h.pending = 0
+		}
+	})
+}
+
+// WithMetrics adds the runs of the pipe to the metrics of the pipeline
+// called name in m. Pipes sharing a name add to the same metrics. Like
+// WithHook it stops the stages being fused.
+func (p *_Pipe) WithMetrics(m *Metrics, name string) *_Pipe {
+	return p.WithHook(&_MetricsHook{m: m, c: m.pipeline(name)})
+}
@@ -0,0 +1,346 @@
+package pipe
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Hook is told what the terminals of a pipe do. stage names a stage as
+// listed by Explain. The methods of a hook used by P* terminals are called
+// concurrently.
+type Hook interface {
+	// OnStart is called when a terminal starts with the number of source
+	// elements.
+	OnStart(total int)
+	// OnItem is called when stage has turned in into out in d.
+	OnItem(stage string, in, out interface{}, d time.Duration)
+	// OnDrop is called when stage has left in out after d.
+	OnDrop(stage string, in interface{}, d time.Duration)
+	// OnFinish is called when a terminal returns, with the error it
+	// returns or panics with.
+	OnFinish(err error)
+}
+
+// _IIndexedHook is implemented by hooks that also want the source index of
+// the elements and the failures sent to dead letters.
+type _IIndexedHook interface {
+	onItemAt(index int, stage string, in, out interface{}, d time.Duration)
+	onDropAt(index int, stage string, in interface{}, d time.Duration)
+	onDeadLetter(letter DeadLetter)
+}
+
+// _IElementHook is implemented by hooks that want to know when an element
+// enters the stages and when it is done with them, kept or not.
+type _IElementHook interface {
+	elementStart(index int)
+	elementDone(index int, kept bool)
+}
+
+// _IBindableHook is implemented by hooks that need to know the pipe they
+// observe. bind returns the hook used by the plan of p.
+type _IBindableHook interface {
+	bind(p *_Pipe) Hook
+}
+
+// _Observer calls the hooks of a compiled plan.
+type _Observer struct {
+	hooks    []Hook
+	progress func(done, total int)
+
+	mu    sync.Mutex
+	total int
+	done  int
+}
+
+func (obs *_Observer) start(total int) {
+	obs.mu.Lock()
+	obs.total, obs.done = total, 0
+	obs.mu.Unlock()
+	for _, h := range obs.hooks {
+		h.OnStart(total)
+	}
+}
+
+func (obs *_Observer) elementStart(index int) {
+	for _, h := range obs.hooks {
+		if eh, ok := h.(_IElementHook); ok {
+			eh.elementStart(index)
+		}
+	}
+}
+
+// elementDone is called once for every source element that went through
+// or was dropped by the stages.
+func (obs *_Observer) elementDone(index int, kept bool) {
+	for _, h := range obs.hooks {
+		if eh, ok := h.(_IElementHook); ok {
+			eh.elementDone(index, kept)
+		}
+	}
+	if obs.progress == nil {
+		return
+	}
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	obs.done++
+	obs.progress(obs.done, obs.total)
+}
+
+func (obs *_Observer) deadLetter(letter DeadLetter) {
+	for _, h := range obs.hooks {
+		if ih, ok := h.(_IIndexedHook); ok {
+			ih.onDeadLetter(letter)
+		}
+	}
+}
+
+func (obs *_Observer) finish(err error) {
+	for _, h := range obs.hooks {
+		h.OnFinish(err)
+	}
+}
+
+// _HookProc reports every call of its inner proc to the hooks.
+type _HookProc struct {
+	inner _IProc
+	obs   *_Observer
+	stage string
+}
+
+func (h *_HookProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	start := time.Now()
+	output, keep := procNext(h.inner, index, input)
+	d := time.Since(start)
+	if !keep {
+		for _, hook := range h.obs.hooks {
+			if ih, ok := hook.(_IIndexedHook); ok {
+				ih.onDropAt(index, h.stage, input.Interface(), d)
+			} else {
+				hook.OnDrop(h.stage, input.Interface(), d)
+			}
+		}
+		h.obs.elementDone(index, false)
+		return output, keep
+	}
+	for _, hook := range h.obs.hooks {
+		if ih, ok := hook.(_IIndexedHook); ok {
+			ih.onItemAt(index, h.stage, input.Interface(), output.Interface(), d)
+		} else {
+			hook.OnItem(h.stage, input.Interface(), output.Interface(), d)
+		}
+	}
+	return output, keep
+}
+
+func (h *_HookProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return h.nextAt(-1, input)
+}
+
+func (h *_HookProc) GetOutType() reflect.Type {
+	return h.inner.GetOutType()
+}
+
+// _StartProc starts the procs of an observed plan.
+type _StartProc struct {
+	Type reflect.Type
+	obs  *_Observer
+}
+
+func (s *_StartProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	s.obs.elementStart(index)
+	return input, true
+}
+
+func (s *_StartProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return s.nextAt(-1, input)
+}
+
+func (s *_StartProc) GetOutType() reflect.Type {
+	return s.Type
+}
+
+// _DoneProc ends the procs of an observed plan and counts the elements
+// reaching it.
+type _DoneProc struct {
+	Type reflect.Type
+	obs  *_Observer
+}
+
+func (d *_DoneProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	d.obs.elementDone(index, true)
+	return input, true
+}
+
+func (d *_DoneProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return d.nextAt(-1, input)
+}
+
+func (d *_DoneProc) GetOutType() reflect.Type {
+	return d.Type
+}
+
+// newObserver returns the observer of the plan of p, binding the hooks
+// that need it.
+func (p *_Pipe) newObserver(conf *_Config) *_Observer {
+	hooks := make([]Hook, len(conf.hooks))
+	for i, h := range conf.hooks {
+		if b, ok := h.(_IBindableHook); ok {
+			h = b.bind(p)
+		}
+		hooks[i] = h
+	}
+	return &_Observer{hooks: hooks, progress: conf.progress}
+}
+
+// observeProcs wraps every proc of chain in a _HookProc between a
+// _StartProc and a _DoneProc. srcType is the element type of the source the
+// plan reads.
+func (p *_Pipe) observeProcs(chain []_IProc, obs *_Observer, srcType reflect.Type) []_IProc {
+	p.wrapProcs(chain, func(i int, name string, inner _IProc) _IProc {
+		if g, ok := inner.(*_GuardProc); ok {
+			g.obs = obs
+		}
+		return &_HookProc{inner: inner, obs: obs, stage: name}
+	})
+	observed := append([]_IProc{&_StartProc{Type: srcType, obs: obs}}, chain...)
+	return append(observed, &_DoneProc{Type: p.getOutType(), obs: obs})
+}
+
+// panicErr turns a recovered panic value into the error given to OnFinish.
+func panicErr(r interface{}) error {
+	if r == nil {
+		return nil
+	}
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
+
+// WithHook adds h to the hooks of the pipe. The stages of an observed pipe
+// are run one by one instead of being fused.
+func (p *_Pipe) WithHook(h Hook) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.hooks = append(conf.hooks[:len(conf.hooks):len(conf.hooks)], h)
+	})
+}
+
+// Progress makes the terminals of the pipe call fn every time an element
+// has gone through the stages or been dropped, with the number of such
+// elements so far and the number of source elements. The calls are
+// serialized.
+func (p *_Pipe) Progress(fn func(done, total int)) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.progress = fn
+	})
+}
+
+// HistogramBounds are the upper bounds of the latency buckets of a
+// StatsCollector; a last bucket holds the slower calls.
+var HistogramBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// StageStats are the numbers a StatsCollector gathered for a stage.
+// Latency[i] counts the calls taking at most HistogramBounds[i], the last
+// element the slower ones.
+type StageStats struct {
+	Stage   string
+	In      int
+	Out     int
+	Dropped int
+	Total   time.Duration
+	Latency []int
+}
+
+// PassRate is the share of the elements the stage kept.
+func (s StageStats) PassRate() float64 {
+	if s.In == 0 {
+		return 0
+	}
+	return float64(s.Out) / float64(s.In)
+}
+
+// StatsCollector is a Hook counting elements and call latencies per stage.
+type StatsCollector struct {
+	mu     sync.Mutex
+	order  []string
+	stages map[string]*StageStats
+}
+
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{stages: make(map[string]*StageStats)}
+}
+
+func (c *StatsCollector) record(stage string, kept bool, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stages[stage]
+	if !ok {
+		s = &StageStats{Stage: stage, Latency: make([]int, len(HistogramBounds)+1)}
+		c.stages[stage] = s
+		c.order = append(c.order, stage)
+	}
+	s.In++
+	if kept {
+		s.Out++
+	} else {
+		s.Dropped++
+	}
+	s.Total += d
+	bucket := len(HistogramBounds)
+	for i, bound := range HistogramBounds {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	s.Latency[bucket]++
+}
+
+func (c *StatsCollector) OnStart(total int) {}
+
+func (c *StatsCollector) OnItem(stage string, in, out interface{}, d time.Duration) {
+	c.record(stage, true, d)
+}
+
+func (c *StatsCollector) OnDrop(stage string, in interface{}, d time.Duration) {
+	c.record(stage, false, d)
+}
+
+func (c *StatsCollector) OnFinish(err error) {}
+
+// Stages returns the stats of every stage in the order they first ran.
+func (c *StatsCollector) Stages() []StageStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]StageStats, len(c.order))
+	for i, name := range c.order {
+		s := *c.stages[name]
+		s.Latency = append([]int(nil), s.Latency...)
+		stats[i] = s
+	}
+	return stats
+}
+
+// String renders the stats as one line per stage.
+func (c *StatsCollector) String() string {
+	var buf bytes.Buffer
+	for _, s := range c.Stages() {
+		var mean time.Duration
+		if s.In > 0 {
+			mean = s.Total / time.Duration(s.In)
+		}
+		fmt.Fprintf(&buf, "%s: in %d, out %d, pass %.1f%%, mean %v, latency %v\n", s.Stage, s.In, s.Out, 100*s.PassRate(), mean, s.Latency)
+	}
+	return buf.String()
+}
@@ -0,0 +1,147 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrExecutorClosed is returned by Submit after Wait has been called.
+var ErrExecutorClosed = errors.New("pipe: executor closed")
+
+type _OrderedTask struct {
+	index int
+	fn    func(context.Context) (interface{}, error)
+}
+
+// OrderedExecutor runs tasks on a fixed number of goroutines and hands their
+// results to a callback strictly in submission order, whatever order they
+// complete in.
+//
+// At most workers tasks are running or waiting for delivery at any time;
+// Submit blocks while that limit is reached. A task that panics is reported
+// to the callback as a *PanicError. When the callback returns an error or
+// the context is done, the executor stops: pending results are dropped,
+// running tasks see their context cancelled, Submit fails and Wait returns
+// that error.
+type OrderedExecutor struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	deliver func(result interface{}, err error) error
+	wait    *WaitIndex
+	tasks   chan _OrderedTask
+	workers sync.WaitGroup
+
+	mu     sync.Mutex
+	next   int
+	closed bool
+
+	errOnce sync.Once
+	err     error
+}
+
+// NewOrderedExecutor starts workers goroutines. deliver is never called
+// concurrently; returning an error from it stops the executor.
+func NewOrderedExecutor(ctx context.Context, workers int, deliver func(result interface{}, err error) error) *OrderedExecutor {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	e := &OrderedExecutor{
+		ctx:     ctx,
+		cancel:  cancel,
+		deliver: deliver,
+		wait:    NewWaitIndex(-1, workers),
+		tasks:   make(chan _OrderedTask, workers),
+	}
+	e.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go e.work()
+	}
+	go func() {
+		<-ctx.Done()
+		e.fail(ctx.Err())
+	}()
+	return e
+}
+
+func (e *OrderedExecutor) fail(err error) {
+	e.errOnce.Do(func() {
+		e.err = err
+		e.wait.Cancel()
+		e.cancel()
+	})
+}
+
+// Err returns the error that stopped the executor, if any.
+func (e *OrderedExecutor) Err() error {
+	if e.ctx.Err() == nil {
+		return nil
+	}
+	e.fail(e.ctx.Err())
+	return e.err
+}
+
+func (e *OrderedExecutor) work() {
+	defer e.workers.Done()
+	for task := range e.tasks {
+		if e.ctx.Err() != nil {
+			continue
+		}
+		result, err := runOrderedTask(e.ctx, task)
+		e.wait.Done(task.index, func() {
+			if e.ctx.Err() != nil {
+				return
+			}
+			if derr := e.deliver(result, err); derr != nil {
+				e.fail(derr)
+			}
+		})
+	}
+}
+
+func runOrderedTask(ctx context.Context, task _OrderedTask) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Index: task.index, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return task.fn(ctx)
+}
+
+// Submit queues fn, blocking while the executor is at capacity. It returns
+// an error instead if the executor has stopped or Wait was called.
+func (e *OrderedExecutor) Submit(fn func(ctx context.Context) (interface{}, error)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrExecutorClosed
+	}
+	index := e.next
+	if !e.wait.Acquire(index) {
+		return e.Err()
+	}
+	select {
+	case e.tasks <- _OrderedTask{index: index, fn: fn}:
+		e.next++
+		return nil
+	case <-e.ctx.Done():
+		return e.Err()
+	}
+}
+
+// Wait blocks until every submitted task has been delivered or the executor
+// has stopped, and returns the error that stopped it.
+func (e *OrderedExecutor) Wait() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.tasks)
+	}
+	e.mu.Unlock()
+	e.workers.Wait()
+	err := e.Err()
+	e.fail(nil)
+	return err
+}
@@ -0,0 +1,253 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PanicError is raised on the calling goroutine of a P* terminal, or
+// returned by its error-aware variant, when a function panics while
+// processing an element. Index is the source index of that element.
+type PanicError struct {
+	Index int
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("pipe: panic while processing element %d: %v\n%s", e.Index, e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value when it is an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// _ParallelRun is shared by the goroutines of one P* terminal. The first
+// panic, or the deadline of the pipe passing, is kept and stops the
+// remaining work.
+type _ParallelRun struct {
+	stopped int32
+	once    sync.Once
+	err     error
+	// stop, if set before the run starts, is called on the first panic.
+	stop func()
+	// expired is closed when the deadline passes. The terminal then returns
+	// without waiting for the elements still being processed; mu keeps
+	// their results from reaching the terminal once it has returned.
+	expired chan struct{}
+	timer   *time.Timer
+	mu      sync.RWMutex
+	// obs, if set, is told when the run finishes
+	obs *_Observer
+}
+
+func newParallelRun() *_ParallelRun {
+	return &_ParallelRun{expired: make(chan struct{})}
+}
+
+func (run *_ParallelRun) Stopped() bool {
+	return atomic.LoadInt32(&run.stopped) != 0
+}
+
+func (run *_ParallelRun) fail(err error) {
+	run.once.Do(func() {
+		run.err = err
+		atomic.StoreInt32(&run.stopped, 1)
+		if run.stop != nil {
+			run.stop()
+		}
+	})
+}
+
+// arm starts the deadline timer of the run, once run.stop is set.
+func (run *_ParallelRun) arm(deadline time.Time) {
+	if deadline.IsZero() || run.timer != nil {
+		return
+	}
+	run.timer = time.AfterFunc(time.Until(deadline), func() {
+		run.fail(ErrDeadline)
+		// also after a panic, which may be waiting for a stuck element
+		close(run.expired)
+	})
+}
+
+// observe starts reporting the run to obs, if the pipe is observed.
+func (run *_ParallelRun) observe(obs *_Observer, total int) {
+	if obs == nil || run.obs != nil {
+		return
+	}
+	run.obs = obs
+	obs.start(total)
+}
+
+// call runs fn for the element at index unless the run is stopped,
+// recording a panic instead of letting it crash the process.
+func (run *_ParallelRun) call(index int, fn func()) {
+	if run.Stopped() {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			run.fail(&PanicError{Index: index, Value: r, Stack: debug.Stack()})
+		}
+	}()
+	fn()
+}
+
+// deliver runs fn, which hands a result to the terminal, unless the run is
+// stopped.
+func (run *_ParallelRun) deliver(fn func()) {
+	run.mu.RLock()
+	defer run.mu.RUnlock()
+	if !run.Stopped() {
+		fn()
+	}
+}
+
+// wait waits for wg, or only for the deliveries in progress once the
+// deadline has passed.
+func (run *_ParallelRun) wait(wg *sync.WaitGroup) {
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-run.expired:
+		run.mu.Lock()
+		run.mu.Unlock()
+	}
+}
+
+// Err returns the recorded panic or ErrDeadline. It must only be called
+// once the run is over.
+func (run *_ParallelRun) Err() error {
+	if run.timer != nil {
+		run.timer.Stop()
+	}
+	// a deadline firing right now is either recorded or prevented
+	run.once.Do(func() {})
+	if run.obs != nil {
+		run.obs.finish(run.err)
+		run.obs = nil
+	}
+	return run.err
+}
+
+// pRun computes the elements of p on the configured executor, at most the
+// configured window at a time, and hands the kept ones to sink. With
+// ordered set sink is called in source order, one item at a time;
+// otherwise it may be called concurrently. A panic stops the run and no
+// goroutine started by pRun is left running when it returns, unless the
+// deadline of the pipe has passed: elements still being processed are then
+// abandoned. Ordered runs apply the Scan stages and the ones after them in
+// source order; unordered runs fail with ErrUnorderedScan.
+func (p *_Pipe) pRun(run *_ParallelRun, ordered bool, sink func(int, reflect.Value)) {
+	length := p.srcLen()
+	conf := p.config()
+	plan := p.getPlan()
+	if !ordered && len(plan.scans) > 0 {
+		run.fail(ErrUnorderedScan)
+		return
+	}
+	defer plan.beginScans()()
+	run.observe(plan.observer, length)
+	window := conf.window
+	if window < 1 {
+		window = 1
+	}
+	// a BatchExecutor is flushed once a window of tasks has been submitted,
+	// right before the loop would block waiting for one of them, and at the
+	// end of the loop
+	batch, _ := conf.executor.(BatchExecutor)
+	flush := func(submitted int) {
+		if batch != nil && (submitted < 0 || submitted%window == 0) {
+			batch.Flush()
+		}
+	}
+	var wg sync.WaitGroup
+	defer run.wait(&wg)
+	if !ordered {
+		run.arm(conf.deadline)
+		sem := make(chan struct{}, window)
+		for i := 0; i < length && !run.Stopped(); i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-run.expired:
+				return
+			}
+			wg.Add(1)
+			task := p.getValue(i)
+			conf.executor.Submit(func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				conf.limit(func() {
+					run.call(task.srcIndex, func() {
+						if item, keep := task.GetValue(); keep {
+							run.deliver(func() {
+								sink(task.srcIndex, item)
+							})
+						}
+					})
+				})
+			})
+			flush(i + 1)
+		}
+		flush(-1)
+		return
+	}
+	wait := NewWaitIndex(length, window)
+	run.stop = wait.Cancel
+	run.arm(conf.deadline)
+	for i := 0; i < length && wait.Acquire(i); i++ {
+		wg.Add(1)
+		task := p.getValue(i)
+		// the procs from the first Scan on run in source order
+		var tail []_IProc
+		if plan.scanAt < len(plan.procs) {
+			tail = task.procList[plan.scanAt:]
+			task.procList = task.procList[:plan.scanAt]
+		}
+		conf.executor.Submit(func() {
+			defer wg.Done()
+			var item reflect.Value
+			keep := false
+			conf.limit(func() {
+				run.call(task.srcIndex, func() {
+					item, keep = task.GetValue()
+				})
+			})
+			if !keep || run.Stopped() {
+				wait.Done(task.srcIndex, nil)
+				return
+			}
+			wait.Done(task.srcIndex, func() {
+				run.call(task.srcIndex, func() {
+					if tail != nil {
+						rest := &_GetValueTask{srcIndex: task.srcIndex, startValue: item, procList: tail}
+						if item, keep = rest.GetValue(); !keep {
+							return
+						}
+					}
+					run.deliver(func() {
+						sink(task.srcIndex, item)
+					})
+				})
+			})
+		})
+		flush(i + 1)
+	}
+	flush(-1)
+	wait.Wait()
+}
@@ -0,0 +1,153 @@
+package pipe
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+)
+
+func (plan *_Plan) srcType() reflect.Type {
+	if plan.rng != nil {
+		return reflect.TypeOf(1)
+	}
+	return plan.srcValue.Type().Elem()
+}
+
+// partitionOf hashes key onto one of n partitions.
+func partitionOf(key reflect.Value, n int) int {
+	var h uint64
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		h = uint64(key.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		h = key.Uint()
+	default:
+		hash := fnv.New64a()
+		if key.Kind() == reflect.String {
+			hash.Write([]byte(key.String()))
+		} else {
+			fmt.Fprintf(hash, "%#v", key.Interface())
+		}
+		h = hash.Sum64()
+	}
+	// spread consecutive integer keys before taking the modulo
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return int(h % uint64(n))
+}
+
+// pRunByKey computes the elements of p on workers goroutines, sending every
+// source element to the worker chosen by hashing keyFunc of it, so elements
+// with the same key go through the stages and sink one at a time in source
+// order. sink is called concurrently for different workers.
+func (p *_Pipe) pRunByKey(run *_ParallelRun, kind string, keyFunc interface{}, workers int, sink func(int, reflect.Value)) {
+	plan := p.getPlan()
+	p.mustFunc(kind+" keyFunc", keyFunc, []interface{}{plan.srcType()}, []interface{}{nil})
+	keyValue := reflect.ValueOf(keyFunc)
+	if workers < 1 {
+		workers = 1
+	}
+	if len(plan.scans) > 0 {
+		run.fail(ErrUnorderedScan)
+		return
+	}
+	conf := p.config()
+	buffer := conf.window / workers
+	if buffer < 1 {
+		buffer = 1
+	}
+	queues := make([]chan *_GetValueTask, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := range queues {
+		queue := make(chan *_GetValueTask, buffer)
+		queues[w] = queue
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				conf.limit(func() {
+					run.call(task.srcIndex, func() {
+						if item, keep := task.GetValue(); keep {
+							sink(task.srcIndex, item)
+						}
+					})
+				})
+			}
+		}()
+	}
+	run.arm(conf.deadline)
+	length := plan.srcLen()
+	run.observe(plan.observer, length)
+	for i := 0; i < length && !run.Stopped(); i++ {
+		task := plan.getValue(i)
+		run.call(i, func() {
+			key := keyValue.Call([]reflect.Value{task.startValue})[0]
+			select {
+			case queues[partitionOf(key, workers)] <- task:
+			case <-run.expired:
+			}
+		})
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	run.wait(&wg)
+}
+
+// PEachByKey calls fn(item, srcIndex) on workers goroutines. keyFunc is
+// called with every source element; elements with the same key run through
+// the stages and fn in source order on the same worker, while different
+// keys run concurrently. Unlike the index of Each and PEach, which counts
+// the kept elements, srcIndex is the source index of the element, as the
+// elements of different keys are kept in no particular order. The workers
+// are goroutines of their own: WithExecutor and the pipetest schedulers do
+// not apply to this terminal nor to PToSliceByKey.
+func (p *_Pipe) PEachByKey(keyFunc interface{}, workers int, fn interface{}) {
+	if err := p.PEachByKeyE(keyFunc, workers, fn); err != nil {
+		panic(err)
+	}
+}
+
+func (p *_Pipe) PEachByKeyE(keyFunc interface{}, workers int, fn interface{}) error {
+	p.mustValid()
+	p.mustFunc("PEachByKey", fn, []interface{}{p.getOutType(), reflect.Int}, []interface{}{})
+	fValue := reflect.ValueOf(fn)
+	run := newParallelRun()
+	p.pRunByKey(run, "PEachByKey", keyFunc, workers, func(srcIndex int, itemValue reflect.Value) {
+		fValue.Call([]reflect.Value{itemValue, reflect.ValueOf(srcIndex)})
+	})
+	return run.Err()
+}
+
+// PToSliceByKey is PToSlice with the stages run as in PEachByKey, which
+// lets stateful stage functions see the elements of each key in order. The
+// result keeps the source order.
+func (p *_Pipe) PToSliceByKey(keyFunc interface{}, workers int) interface{} {
+	result, err := p.PToSliceByKeyE(keyFunc, workers)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func (p *_Pipe) PToSliceByKeyE(keyFunc interface{}, workers int) (interface{}, error) {
+	p.mustValid()
+	length := p.srcLen()
+	items := make([]reflect.Value, length)
+	run := newParallelRun()
+	p.pRunByKey(run, "PToSliceByKey", keyFunc, workers, func(i int, itemValue reflect.Value) {
+		items[i] = itemValue
+	})
+	if err := run.Err(); err != nil {
+		return nil, err
+	}
+	builder := newSliceBuilder(p.getOutType(), length)
+	for _, item := range items {
+		if item.IsValid() {
+			builder.Append(item)
+		}
+	}
+	return builder.Result(), nil
+}
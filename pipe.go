@@ -3,6 +3,7 @@ package pipe
 import (
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"sort"
 	"sync"
 )
@@ -33,7 +34,7 @@ func isGoodFunc(fType reflect.Type, intypes, outtypes []interface{}) bool {
 		if t == nil {
 			continue
 		}
-		if tt, ok := t.(reflect.Type); ok && !isTypeMatched(tt, argType) {
+		if tt, ok := t.(reflect.Type); ok && !isTypeMatched(argType, tt) {
 			return false
 		}
 		if tk, ok := t.(reflect.Kind); ok && tk != argType.Kind() {
@@ -66,6 +67,7 @@ type _MapProc struct {
 	InType  reflect.Type
 	OutType reflect.Type
 	Func    reflect.Value
+	fast    _StepFunc
 }
 
 func newMapProc(f interface{}, intype reflect.Type) *_MapProc {
@@ -85,6 +87,7 @@ func newMapProc(f interface{}, intype reflect.Type) *_MapProc {
 			InType:  fType.In(0),
 			OutType: fType.Out(0),
 			Func:    fValue,
+			fast:    fastMapStep(f),
 		}
 	}
 }
@@ -95,21 +98,43 @@ func (m *_MapProc) Next(input reflect.Value) (reflect.Value, bool) {
 		panic(fmt.Sprintf("input type error. want %v got %v", m.InType, inType))
 	}
 	if m.Func.IsValid() {
-		outs := m.Func.Call([]reflect.Value{input})
-		return outs[0], true
+		return m.call(input)
 	} else {
 		return input, true
 	}
 }
 
+func (m *_MapProc) call(input reflect.Value) (reflect.Value, bool) {
+	if m.fast != nil {
+		return m.fast(input)
+	}
+	outs := m.Func.Call([]reflect.Value{input})
+	return outs[0], true
+}
+
 func (m *_MapProc) GetOutType() reflect.Type {
 	return m.OutType
 }
 
+func (m *_MapProc) GetInType() reflect.Type {
+	return m.InType
+}
+
+func (m *_MapProc) step(checked bool) _StepFunc {
+	if checked || !m.Func.IsValid() {
+		return m.Next
+	}
+	if m.fast != nil {
+		return m.fast
+	}
+	return m.call
+}
+
 type _FilterProc struct {
 	InType  reflect.Type
 	OutType reflect.Type
 	Func    reflect.Value
+	fast    _StepFunc
 }
 
 func newFilterProc(f interface{}) *_FilterProc {
@@ -122,6 +147,7 @@ func newFilterProc(f interface{}) *_FilterProc {
 		InType:  fType.In(0),
 		OutType: fType.In(0),
 		Func:    fValue,
+		fast:    fastFilterStep(f),
 	}
 }
 
@@ -130,19 +156,48 @@ func (f *_FilterProc) Next(input reflect.Value) (reflect.Value, bool) {
 	if !isTypeMatched(f.InType, inType) {
 		panic(fmt.Sprintf("input type error. want %v got %v", f.InType, inType))
 	}
+	return f.call(input)
+}
+
+func (f *_FilterProc) call(input reflect.Value) (reflect.Value, bool) {
+	if f.fast != nil {
+		return f.fast(input)
+	}
 	outs := f.Func.Call([]reflect.Value{input})
-	passed := outs[0].Interface().(bool)
-	return input, passed
+	return input, outs[0].Bool()
 }
 
 func (f *_FilterProc) GetOutType() reflect.Type {
 	return f.OutType
 }
 
+func (f *_FilterProc) GetInType() reflect.Type {
+	return f.InType
+}
+
+func (f *_FilterProc) step(checked bool) _StepFunc {
+	if checked {
+		return f.Next
+	}
+	if f.fast != nil {
+		return f.fast
+	}
+	return f.call
+}
+
 type _Pipe struct {
-	arr     interface{}
-	srcPipe *_Pipe
-	proc    _IProc
+	arr      interface{}
+	srcPipe  *_Pipe
+	proc     _IProc
+	err      *PipeError
+	conf     *_Config
+	plan     *_Plan
+	planOnce sync.Once
+	// origin and originStage record the pipe consumed by Sort, Uniq or
+	// Reverse to build arr; srcDesc describes a source built by _MapPipe.
+	origin      *_Pipe
+	originStage *_StageDesc
+	srcDesc     string
 }
 
 type _Range struct {
@@ -185,34 +240,28 @@ func Range(args ...int) *_Pipe {
 }
 
 func (p *_Pipe) Filter(proc interface{}) *_Pipe {
-	return &_Pipe{
-		arr:     nil,
-		srcPipe: p,
-		proc:    newFilterProc(proc),
-	}
+	return p.addStage("filter", proc, []interface{}{p.getOutType()}, []interface{}{reflect.Bool}, func() _IProc {
+		return newFilterProc(proc)
+	})
 }
 
 func (p *_Pipe) Map(proc interface{}) *_Pipe {
-	return &_Pipe{
-		arr:     nil,
-		srcPipe: p,
-		proc:    newMapProc(proc, p.getOutType()),
+	if proc == nil {
+		if p.err != nil {
+			return p.withError(p.err)
+		}
+		return &_Pipe{
+			srcPipe: p,
+			proc:    newMapProc(nil, p.getOutType()),
+		}
 	}
+	return p.addStage("map", proc, []interface{}{p.getOutType()}, []interface{}{nil}, func() _IProc {
+		return newMapProc(proc, p.getOutType())
+	})
 }
 
 func (p *_Pipe) srcLen() int {
-	pp := p
-	for pp.srcPipe != nil {
-		pp = pp.srcPipe
-	}
-	if pp.arr == nil {
-		panic("no slice")
-	}
-	if r, ok := pp.arr.(*_Range); ok {
-		return ((*r).end - (*r).begin) / (*r).step
-	} else {
-		return reflect.ValueOf(pp.arr).Len()
-	}
+	return p.getPlan().srcLen()
 }
 
 type _GetValueTask struct {
@@ -224,9 +273,9 @@ type _GetValueTask struct {
 func (t *_GetValueTask) GetValue() (item reflect.Value, keep bool) {
 	item = t.startValue
 	keep = true
-	if t.procList != nil {
+	if len(t.procList) > 0 {
 		for _, proc := range t.procList {
-			item, keep = proc.Next(item)
+			item, keep = procNext(proc, t.srcIndex, item)
 			if !keep {
 				return
 			}
@@ -235,82 +284,15 @@ func (t *_GetValueTask) GetValue() (item reflect.Value, keep bool) {
 	return
 }
 
-func (t *_GetValueTask) PGetValue() (item reflect.Value, keep bool) {
-	item = t.startValue
-	keep = true
-	if t.procList != nil {
-		done := make(chan int, 1)
-		go func() {
-			for _, proc := range t.procList {
-				item, keep = proc.Next(item)
-				if !keep {
-					break
-				}
-			}
-			done <- 1
-		}()
-		<-done
-		close(done)
-	}
-	return
-}
-
-func (t *_GetValueTask) Then(fn func(reflect.Value)) {
-	item, keep := t.PGetValue()
-	if keep {
-		fn(item)
-	}
-}
-
-func (t *_GetValueTask) UnstableThen(wg *sync.WaitGroup, fn func(reflect.Value)) {
-	go func() {
-		defer wg.Done()
-		item, keep := t.PGetValue()
-		if keep {
-			fn(item)
-		}
-	}()
-}
-
-func (t *_GetValueTask) StableThen(wait *WaitIndex, fn func(reflect.Value)) {
-	go func() {
-		item, keep := t.PGetValue()
-		if keep {
-			wait.Wait(t.srcIndex - 1)
-			fn(item)
-		}
-		wait.Done(t.srcIndex)
-	}()
-}
-
-func (p *_Pipe) getValue(index int) (task *_GetValueTask) {
-	if p.srcPipe != nil {
-		task = p.srcPipe.getValue(index)
-	} else if r, ok := p.arr.(*_Range); ok {
-		task = &_GetValueTask{
-			srcIndex:   index,
-			startValue: reflect.ValueOf((*r).begin + index*(*r).step),
-		}
-	} else {
-		task = &_GetValueTask{
-			srcIndex:   index,
-			startValue: reflect.ValueOf(p.arr).Index(index),
-		}
-	}
-	if p.proc != nil {
-		if task.procList == nil {
-			task.procList = make([]_IProc, 1, 10)
-			task.procList[0] = p.proc
-		} else {
-			task.procList = append(task.procList, p.proc)
-		}
-	}
-	return
+func (p *_Pipe) getValue(index int) *_GetValueTask {
+	return p.getPlan().getValue(index)
 }
 
 func (p *_Pipe) getOutType() reflect.Type {
 	if p.proc != nil {
 		return p.proc.GetOutType()
+	} else if p.srcPipe != nil {
+		return p.srcPipe.getOutType()
 	} else if p.arr != nil {
 		if _, ok := p.arr.(*_Range); ok {
 			return reflect.TypeOf(1)
@@ -322,10 +304,13 @@ func (p *_Pipe) getOutType() reflect.Type {
 }
 
 func (p *_Pipe) ToSlice() interface{} {
-	if p.proc == nil {
+	p.mustValid()
+	// only a pipe without stages nor settings returns its source as is;
+	// the stages an empty plan drops still give the caller a copy
+	if p.srcPipe == nil {
 		if r, ok := p.arr.(*_Range); ok {
 			out := make([]int, 0, p.srcLen())
-			for i := (*r).begin; i < (*r).end; i += (*r).step {
+			for i := r.begin; i < r.end; i += r.step {
 				out = append(out, i)
 			}
 			return out
@@ -334,84 +319,183 @@ func (p *_Pipe) ToSlice() interface{} {
 	}
 	length := p.srcLen()
 	outElemType := p.getOutType()
-	newSliceType := reflect.SliceOf(outElemType)
-	newSliceValue := reflect.MakeSlice(newSliceType, 0, length)
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			newSliceValue = reflect.Append(newSliceValue, itemValue)
-		})
-	}
-	return newSliceValue.Interface()
+	builder := newSliceBuilder(outElemType, length)
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		builder.Append(itemValue)
+		return true
+	})
+	return builder.Result()
 }
 
 func (p *_Pipe) PToSlice() interface{} {
-	if p.proc == nil {
-		return p.ToSlice()
+	out, err := p.PToSliceE()
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (p *_Pipe) PToSliceE() (interface{}, error) {
+	p.mustValid()
+	if len(p.getPlan().procs) == 0 {
+		return p.ToSlice(), nil
 	}
 	length := p.srcLen()
 	outElemType := p.getOutType()
-	newSliceType := reflect.SliceOf(outElemType)
-	newSliceValue := reflect.MakeSlice(newSliceType, 0, length)
-	waitIndex := NewWaitIndex(length)
-	for i := 0; i < length; i++ {
-		p.getValue(i).StableThen(waitIndex, func(itemValue reflect.Value) {
-			newSliceValue = reflect.Append(newSliceValue, itemValue)
-		})
+	builder := newSliceBuilder(outElemType, length)
+	run := newParallelRun()
+	p.pRun(run, true, func(i int, itemValue reflect.Value) {
+		builder.Append(itemValue)
+	})
+	if err := run.Err(); err != nil {
+		return nil, err
 	}
-	waitIndex.WaitAndClose()
-	return newSliceValue.Interface()
+	return builder.Result(), nil
 }
 
 func (p *_Pipe) Each(fn interface{}) {
-	fType := reflect.TypeOf(fn)
-	if !isGoodFunc(fType, []interface{}{p.getOutType(), reflect.Int}, []interface{}{}) {
-		panic("Invalid each process function")
-	}
+	p.mustValid()
+	p.mustFunc("Each", fn, []interface{}{p.getOutType(), reflect.Int}, []interface{}{})
 	fValue := reflect.ValueOf(fn)
-	length := p.srcLen()
 	index := 0
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			fValue.Call([]reflect.Value{itemValue, reflect.ValueOf(index)})
-			index++
-		})
-	}
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		fValue.Call([]reflect.Value{itemValue, reflect.ValueOf(index)})
+		index++
+		return true
+	})
 }
 
 func (p *_Pipe) PEach(fn interface{}) {
-	fType := reflect.TypeOf(fn)
-	if !isGoodFunc(fType, []interface{}{p.getOutType(), reflect.Int}, []interface{}{}) {
-		panic("Invalid each process function")
+	if err := p.PEachE(fn); err != nil {
+		panic(err)
 	}
+}
+
+func (p *_Pipe) PEachE(fn interface{}) error {
+	p.mustValid()
+	p.mustFunc("PEach", fn, []interface{}{p.getOutType(), reflect.Int}, []interface{}{})
 	fValue := reflect.ValueOf(fn)
 	var wg sync.WaitGroup
-	length := p.srcLen()
 	index := 0
-	wi := NewWaitIndex(length)
-	for i := 0; i < length; i++ {
-		p.getValue(i).StableThen(wi, func(itemValue reflect.Value) {
-			wg.Add(1)
-			go func(index int) {
-				defer wg.Done()
-				fValue.Call([]reflect.Value{itemValue, reflect.ValueOf(index)})
-			}(index)
-			index++
+	conf := p.config()
+	run := newParallelRun()
+	p.pRun(run, true, func(i int, itemValue reflect.Value) {
+		wg.Add(1)
+		outIndex := index
+		conf.executor.Submit(func() {
+			defer wg.Done()
+			conf.limit(func() {
+				run.call(i, func() {
+					fValue.Call([]reflect.Value{itemValue, reflect.ValueOf(outIndex)})
+				})
+			})
 		})
+		index++
+	})
+	run.wait(&wg)
+	return run.Err()
+}
+
+// PEachWorker calls fn(state, item, index) on n workers, each with its own
+// state created by setup on that worker before its first element. Every
+// started worker runs setup, even once the run has been stopped by a
+// panic, and teardown, if not nil, is called with the state of every
+// worker whose setup returned once it has no more elements. A panic in
+// setup or teardown is reported with Index -1. The stages run on the
+// executor set by WithExecutor, but the workers are goroutines of their
+// own, which neither that executor nor a pipetest scheduler controls.
+func (p *_Pipe) PEachWorker(n int, setup, fn, teardown interface{}) {
+	if err := p.PEachWorkerE(n, setup, fn, teardown); err != nil {
+		panic(err)
+	}
+}
+
+func (p *_Pipe) PEachWorkerE(n int, setup, fn, teardown interface{}) error {
+	p.mustValid()
+	p.mustFunc("PEachWorker setup", setup, []interface{}{}, []interface{}{nil})
+	setupValue := reflect.ValueOf(setup)
+	stateType := setupValue.Type().Out(0)
+	p.mustFunc("PEachWorker", fn, []interface{}{stateType, p.getOutType(), reflect.Int}, []interface{}{})
+	fValue := reflect.ValueOf(fn)
+	teardownValue := reflect.ValueOf(teardown)
+	if teardownValue.IsValid() {
+		p.mustFunc("PEachWorker teardown", teardown, []interface{}{stateType}, []interface{}{})
+	}
+	if n < 1 {
+		n = 1
 	}
-	wi.WaitAndClose()
-	wg.Wait()
+	type _WorkerItem struct {
+		srcIndex, index int
+		value           reflect.Value
+	}
+	items := make(chan _WorkerItem)
+	conf := p.config()
+	run := newParallelRun()
+	var wg sync.WaitGroup
+	var startOnce sync.Once
+	// workers start with the first element, once pRun has set up the run
+	start := func() {
+		wg.Add(n)
+		for w := 0; w < n; w++ {
+			go func() {
+				defer wg.Done()
+				var state reflect.Value
+				// setup and teardown are not tied to an element and run
+				// even when the run has been stopped, so that they pair up
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							run.fail(&PanicError{Index: -1, Value: r, Stack: debug.Stack()})
+						}
+					}()
+					state = setupValue.Call(nil)[0]
+				}()
+				if state.IsValid() && teardownValue.IsValid() {
+					defer func() {
+						defer func() {
+							if r := recover(); r != nil {
+								run.fail(&PanicError{Index: -1, Value: r, Stack: debug.Stack()})
+							}
+						}()
+						teardownValue.Call([]reflect.Value{state})
+					}()
+				}
+				for item := range items {
+					if !state.IsValid() {
+						continue
+					}
+					conf.limit(func() {
+						run.call(item.srcIndex, func() {
+							fValue.Call([]reflect.Value{state, item.value, reflect.ValueOf(item.index)})
+						})
+					})
+				}
+			}()
+		}
+	}
+	index := 0
+	p.pRun(run, true, func(i int, itemValue reflect.Value) {
+		startOnce.Do(start)
+		select {
+		case items <- _WorkerItem{i, index, itemValue}:
+		case <-run.expired:
+		}
+		index++
+	})
+	close(items)
+	run.wait(&wg)
+	return run.Err()
 }
 
 func (p *_Pipe) ToMap(getKey, getVal interface{}) interface{} {
+	p.mustValid()
 	outElemType := p.getOutType()
 	var keyType, valType reflect.Type
 	getKeyValue := reflect.ValueOf(getKey)
 	var realGetKey, realGetVal func(reflect.Value) reflect.Value
 	if getKeyValue.IsValid() {
 		getKeyType := getKeyValue.Type()
-		if !isGoodFunc(getKeyType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getKey func invalid")
-		}
+		p.mustFunc("ToMap getKey", getKey, []interface{}{outElemType}, []interface{}{nil})
 		keyType = getKeyType.Out(0)
 		realGetKey = func(input reflect.Value) reflect.Value {
 			return getKeyValue.Call([]reflect.Value{input})[0]
@@ -423,9 +507,7 @@ func (p *_Pipe) ToMap(getKey, getVal interface{}) interface{} {
 	getValValue := reflect.ValueOf(getVal)
 	if getValValue.IsValid() {
 		getValType := getValValue.Type()
-		if !isGoodFunc(getValType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getVal func invalid")
-		}
+		p.mustFunc("ToMap getVal", getVal, []interface{}{outElemType}, []interface{}{nil})
 		valType = getValType.Out(0)
 		realGetVal = func(input reflect.Value) reflect.Value {
 			return getValValue.Call([]reflect.Value{input})[0]
@@ -435,25 +517,30 @@ func (p *_Pipe) ToMap(getKey, getVal interface{}) interface{} {
 		realGetVal = noop
 	}
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, valType))
-	length := p.srcLen()
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			newMapValue.SetMapIndex(realGetKey(itemValue), realGetVal(itemValue))
-		})
-	}
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		newMapValue.SetMapIndex(realGetKey(itemValue), realGetVal(itemValue))
+		return true
+	})
 	return newMapValue.Interface()
 }
 
 func (p *_Pipe) PToMap(getKey, getVal interface{}) interface{} {
+	out, err := p.PToMapE(getKey, getVal)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (p *_Pipe) PToMapE(getKey, getVal interface{}) (interface{}, error) {
+	p.mustValid()
 	outElemType := p.getOutType()
 	var keyType, valType reflect.Type
 	getKeyValue := reflect.ValueOf(getKey)
 	var realGetKey, realGetVal func(reflect.Value) reflect.Value
 	if getKeyValue.IsValid() {
 		getKeyType := getKeyValue.Type()
-		if !isGoodFunc(getKeyType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getKey func invalid")
-		}
+		p.mustFunc("PToMap getKey", getKey, []interface{}{outElemType}, []interface{}{nil})
 		keyType = getKeyType.Out(0)
 		realGetKey = func(input reflect.Value) reflect.Value {
 			return getKeyValue.Call([]reflect.Value{input})[0]
@@ -465,9 +552,7 @@ func (p *_Pipe) PToMap(getKey, getVal interface{}) interface{} {
 	getValValue := reflect.ValueOf(getVal)
 	if getValValue.IsValid() {
 		getValType := getValValue.Type()
-		if !isGoodFunc(getValType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getVal func invalid")
-		}
+		p.mustFunc("PToMap getVal", getVal, []interface{}{outElemType}, []interface{}{nil})
 		valType = getValType.Out(0)
 		realGetVal = func(input reflect.Value) reflect.Value {
 			return getValValue.Call([]reflect.Value{input})[0]
@@ -477,77 +562,76 @@ func (p *_Pipe) PToMap(getKey, getVal interface{}) interface{} {
 		realGetVal = noop
 	}
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, valType))
-	length := p.srcLen()
-	var wg sync.WaitGroup
-	wg.Add(length)
 	var lock sync.Mutex
-	for i := 0; i < length; i++ {
-		p.getValue(i).UnstableThen(&wg, func(itemValue reflect.Value) {
-			lock.Lock()
-			newMapValue.SetMapIndex(realGetKey(itemValue), realGetVal(itemValue))
-			lock.Unlock()
-		})
+	run := newParallelRun()
+	p.pRun(run, false, func(i int, itemValue reflect.Value) {
+		lock.Lock()
+		newMapValue.SetMapIndex(realGetKey(itemValue), realGetVal(itemValue))
+		lock.Unlock()
+	})
+	if err := run.Err(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
-	return newMapValue.Interface()
+	return newMapValue.Interface(), nil
 }
 
 func (p *_Pipe) ToMap2(getPair interface{}) interface{} {
+	p.mustValid()
 	getPairValue := reflect.ValueOf(getPair)
-	getPairType := getPairValue.Type()
 	outElemType := p.getOutType()
-	if !isGoodFunc(getPairType, []interface{}{outElemType}, []interface{}{nil, nil}) {
-		panic("getPair func invalid")
-	}
+	p.mustFunc("ToMap2 getPair", getPair, []interface{}{outElemType}, []interface{}{nil, nil})
+	getPairType := getPairValue.Type()
 	keyType := getPairType.Out(0)
 	valType := getPairType.Out(1)
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, valType))
-	length := p.srcLen()
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			outs := getPairValue.Call([]reflect.Value{itemValue})
-			newMapValue.SetMapIndex(outs[0], outs[1])
-		})
-	}
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		outs := getPairValue.Call([]reflect.Value{itemValue})
+		newMapValue.SetMapIndex(outs[0], outs[1])
+		return true
+	})
 	return newMapValue.Interface()
 }
 
 func (p *_Pipe) PToMap2(getPair interface{}) interface{} {
+	out, err := p.PToMap2E(getPair)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (p *_Pipe) PToMap2E(getPair interface{}) (interface{}, error) {
+	p.mustValid()
 	getPairValue := reflect.ValueOf(getPair)
-	getPairType := getPairValue.Type()
 	outElemType := p.getOutType()
-	if !isGoodFunc(getPairType, []interface{}{outElemType}, []interface{}{nil, nil}) {
-		panic("getPair func invalid")
-	}
+	p.mustFunc("PToMap2 getPair", getPair, []interface{}{outElemType}, []interface{}{nil, nil})
+	getPairType := getPairValue.Type()
 	keyType := getPairType.Out(0)
 	valType := getPairType.Out(1)
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, valType))
-	length := p.srcLen()
-	var wg sync.WaitGroup
-	wg.Add(length)
 	var lock sync.Mutex
-	for i := 0; i < length; i++ {
-		p.getValue(i).UnstableThen(&wg, func(itemValue reflect.Value) {
-			outs := getPairValue.Call([]reflect.Value{itemValue})
-			lock.Lock()
-			newMapValue.SetMapIndex(outs[0], outs[1])
-			lock.Unlock()
-		})
+	run := newParallelRun()
+	p.pRun(run, false, func(i int, itemValue reflect.Value) {
+		outs := getPairValue.Call([]reflect.Value{itemValue})
+		lock.Lock()
+		newMapValue.SetMapIndex(outs[0], outs[1])
+		lock.Unlock()
+	})
+	if err := run.Err(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
-	return newMapValue.Interface()
+	return newMapValue.Interface(), nil
 }
 
 func (p *_Pipe) ToGroupMap(getKey, getVal interface{}) interface{} {
+	p.mustValid()
 	outElemType := p.getOutType()
 	var keyType, valType reflect.Type
 	getKeyValue := reflect.ValueOf(getKey)
 	var realGetKey, realGetVal func(reflect.Value) reflect.Value
 	if getKeyValue.IsValid() {
 		getKeyType := getKeyValue.Type()
-		if !isGoodFunc(getKeyType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getKey func invalid")
-		}
+		p.mustFunc("ToGroupMap getKey", getKey, []interface{}{outElemType}, []interface{}{nil})
 		keyType = getKeyType.Out(0)
 		realGetKey = func(input reflect.Value) reflect.Value {
 			return getKeyValue.Call([]reflect.Value{input})[0]
@@ -559,9 +643,7 @@ func (p *_Pipe) ToGroupMap(getKey, getVal interface{}) interface{} {
 	getValValue := reflect.ValueOf(getVal)
 	if getValValue.IsValid() {
 		getValType := getValValue.Type()
-		if !isGoodFunc(getValType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getVal func invalid")
-		}
+		p.mustFunc("ToGroupMap getVal", getVal, []interface{}{outElemType}, []interface{}{nil})
 		valType = getValType.Out(0)
 		realGetVal = func(input reflect.Value) reflect.Value {
 			return getValValue.Call([]reflect.Value{input})[0]
@@ -573,31 +655,37 @@ func (p *_Pipe) ToGroupMap(getKey, getVal interface{}) interface{} {
 	sliceType := reflect.SliceOf(valType)
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, sliceType))
 	length := p.srcLen()
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			keyValue := realGetKey(itemValue)
-			valValue := realGetVal(itemValue)
-			slot := newMapValue.MapIndex(keyValue)
-			if !slot.IsValid() {
-				slot = reflect.MakeSlice(sliceType, 0, length-i)
-			}
-			slot = reflect.Append(slot, valValue)
-			newMapValue.SetMapIndex(keyValue, slot)
-		})
-	}
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		keyValue := realGetKey(itemValue)
+		valValue := realGetVal(itemValue)
+		slot := newMapValue.MapIndex(keyValue)
+		if !slot.IsValid() {
+			slot = reflect.MakeSlice(sliceType, 0, length-i)
+		}
+		slot = reflect.Append(slot, valValue)
+		newMapValue.SetMapIndex(keyValue, slot)
+		return true
+	})
 	return newMapValue.Interface()
 }
 
 func (p *_Pipe) PToGroupMap(getKey, getVal interface{}) interface{} {
+	out, err := p.PToGroupMapE(getKey, getVal)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (p *_Pipe) PToGroupMapE(getKey, getVal interface{}) (interface{}, error) {
+	p.mustValid()
 	outElemType := p.getOutType()
 	var keyType, valType reflect.Type
 	getKeyValue := reflect.ValueOf(getKey)
 	var realGetKey, realGetVal func(reflect.Value) reflect.Value
 	if getKeyValue.IsValid() {
 		getKeyType := getKeyValue.Type()
-		if !isGoodFunc(getKeyType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getKey func invalid")
-		}
+		p.mustFunc("PToGroupMap getKey", getKey, []interface{}{outElemType}, []interface{}{nil})
 		keyType = getKeyType.Out(0)
 		realGetKey = func(input reflect.Value) reflect.Value {
 			return getKeyValue.Call([]reflect.Value{input})[0]
@@ -609,9 +697,7 @@ func (p *_Pipe) PToGroupMap(getKey, getVal interface{}) interface{} {
 	getValValue := reflect.ValueOf(getVal)
 	if getValValue.IsValid() {
 		getValType := getValValue.Type()
-		if !isGoodFunc(getValType, []interface{}{outElemType}, []interface{}{nil}) {
-			panic("getVal func invalid")
-		}
+		p.mustFunc("PToGroupMap getVal", getVal, []interface{}{outElemType}, []interface{}{nil})
 		valType = getValType.Out(0)
 		realGetVal = func(input reflect.Value) reflect.Value {
 			return getValValue.Call([]reflect.Value{input})[0]
@@ -623,165 +709,156 @@ func (p *_Pipe) PToGroupMap(getKey, getVal interface{}) interface{} {
 	sliceType := reflect.SliceOf(valType)
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, sliceType))
 	length := p.srcLen()
-	wi := NewWaitIndex(length)
-	for i := 0; i < length; i++ {
-		p.getValue(i).StableThen(wi, func(itemValue reflect.Value) {
-			keyValue := realGetKey(itemValue)
-			valValue := realGetVal(itemValue)
-			slot := newMapValue.MapIndex(keyValue)
-			if !slot.IsValid() {
-				slot = reflect.MakeSlice(sliceType, 0, length-i)
-			}
-			slot = reflect.Append(slot, valValue)
-			newMapValue.SetMapIndex(keyValue, slot)
-		})
+	run := newParallelRun()
+	p.pRun(run, true, func(i int, itemValue reflect.Value) {
+		keyValue := realGetKey(itemValue)
+		valValue := realGetVal(itemValue)
+		slot := newMapValue.MapIndex(keyValue)
+		if !slot.IsValid() {
+			slot = reflect.MakeSlice(sliceType, 0, length-i)
+		}
+		slot = reflect.Append(slot, valValue)
+		newMapValue.SetMapIndex(keyValue, slot)
+	})
+	if err := run.Err(); err != nil {
+		return nil, err
 	}
-	wi.WaitAndClose()
-	return newMapValue.Interface()
+	return newMapValue.Interface(), nil
 }
 
 func (p *_Pipe) ToGroupMap2(getPair interface{}) interface{} {
+	p.mustValid()
 	getPairValue := reflect.ValueOf(getPair)
-	getPairType := getPairValue.Type()
 	outElemType := p.getOutType()
-	if !isGoodFunc(getPairType, []interface{}{outElemType}, []interface{}{nil, nil}) {
-		panic("getPair func invalid")
-	}
+	p.mustFunc("ToGroupMap2 getPair", getPair, []interface{}{outElemType}, []interface{}{nil, nil})
+	getPairType := getPairValue.Type()
 	keyType := getPairType.Out(0)
 	valType := getPairType.Out(1)
 	sliceType := reflect.SliceOf(valType)
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, sliceType))
 	length := p.srcLen()
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			outs := getPairValue.Call([]reflect.Value{itemValue})
-			keyValue := outs[0]
-			valValue := outs[1]
-			slot := newMapValue.MapIndex(keyValue)
-			if !slot.IsValid() {
-				slot = reflect.MakeSlice(sliceType, 0, length-i)
-			}
-			slot = reflect.Append(slot, valValue)
-			newMapValue.SetMapIndex(keyValue, slot)
-		})
-	}
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		outs := getPairValue.Call([]reflect.Value{itemValue})
+		keyValue := outs[0]
+		valValue := outs[1]
+		slot := newMapValue.MapIndex(keyValue)
+		if !slot.IsValid() {
+			slot = reflect.MakeSlice(sliceType, 0, length-i)
+		}
+		slot = reflect.Append(slot, valValue)
+		newMapValue.SetMapIndex(keyValue, slot)
+		return true
+	})
 	return newMapValue.Interface()
 }
 
 func (p *_Pipe) PToGroupMap2(getPair interface{}) interface{} {
+	out, err := p.PToGroupMap2E(getPair)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (p *_Pipe) PToGroupMap2E(getPair interface{}) (interface{}, error) {
+	p.mustValid()
 	getPairValue := reflect.ValueOf(getPair)
-	getPairType := getPairValue.Type()
 	outElemType := p.getOutType()
-	if !isGoodFunc(getPairType, []interface{}{outElemType}, []interface{}{nil, nil}) {
-		panic("getPair func invalid")
-	}
+	p.mustFunc("PToGroupMap2 getPair", getPair, []interface{}{outElemType}, []interface{}{nil, nil})
+	getPairType := getPairValue.Type()
 	keyType := getPairType.Out(0)
 	valType := getPairType.Out(1)
 	sliceType := reflect.SliceOf(valType)
 	newMapValue := reflect.MakeMap(reflect.MapOf(keyType, sliceType))
 	length := p.srcLen()
-	wi := NewWaitIndex(length)
-	for i := 0; i < length; i++ {
-		p.getValue(i).StableThen(wi, func(itemValue reflect.Value) {
-			outs := getPairValue.Call([]reflect.Value{itemValue})
-			keyValue := outs[0]
-			valValue := outs[1]
-			slot := newMapValue.MapIndex(keyValue)
-			if !slot.IsValid() {
-				slot = reflect.MakeSlice(sliceType, 0, length-i)
-			}
-			slot = reflect.Append(slot, valValue)
-			newMapValue.SetMapIndex(keyValue, slot)
-		})
+	run := newParallelRun()
+	p.pRun(run, true, func(i int, itemValue reflect.Value) {
+		outs := getPairValue.Call([]reflect.Value{itemValue})
+		keyValue := outs[0]
+		valValue := outs[1]
+		slot := newMapValue.MapIndex(keyValue)
+		if !slot.IsValid() {
+			slot = reflect.MakeSlice(sliceType, 0, length-i)
+		}
+		slot = reflect.Append(slot, valValue)
+		newMapValue.SetMapIndex(keyValue, slot)
+	})
+	if err := run.Err(); err != nil {
+		return nil, err
 	}
-	wi.WaitAndClose()
-	return newMapValue.Interface()
+	return newMapValue.Interface(), nil
 }
 
 func (p *_Pipe) Reduce(initValue interface{}, proc interface{}) interface{} {
-	length := p.srcLen()
+	p.mustValid()
 	outElemType := p.getOutType()
 	procValue := reflect.ValueOf(proc)
-	procType := procValue.Type()
 	initType := reflect.TypeOf(initValue)
-	if !isGoodFunc(procType, []interface{}{initType, outElemType}, []interface{}{initType}) {
-		panic("reduce function invalid")
-	}
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			outs := procValue.Call([]reflect.Value{reflect.ValueOf(initValue), itemValue})
-			initValue = outs[0].Interface()
-		})
-	}
+	p.mustFunc("Reduce", proc, []interface{}{initType, outElemType}, []interface{}{initType})
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		outs := procValue.Call([]reflect.Value{reflect.ValueOf(initValue), itemValue})
+		initValue = outs[0].Interface()
+		return true
+	})
 	return initValue
 }
 
 func (p *_Pipe) PReduce(initValue interface{}, proc interface{}) interface{} {
-	length := p.srcLen()
+	out, err := p.PReduceE(initValue, proc)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (p *_Pipe) PReduceE(initValue interface{}, proc interface{}) (interface{}, error) {
+	p.mustValid()
 	outElemType := p.getOutType()
 	procValue := reflect.ValueOf(proc)
-	procType := procValue.Type()
 	initType := reflect.TypeOf(initValue)
-	if !isGoodFunc(procType, []interface{}{initType, outElemType}, []interface{}{initType}) {
-		panic("reduce function invalid")
-	}
+	p.mustFunc("PReduce", proc, []interface{}{initType, outElemType}, []interface{}{initType})
 	var lock sync.Mutex
-	var wg sync.WaitGroup
-	wg.Add(length)
-	for i := 0; i < length; i++ {
-		p.getValue(i).UnstableThen(&wg, func(itemValue reflect.Value) {
-			lock.Lock()
-			defer lock.Unlock()
-			outs := procValue.Call([]reflect.Value{reflect.ValueOf(initValue), itemValue})
-			initValue = outs[0].Interface()
-		})
+	run := newParallelRun()
+	p.pRun(run, false, func(i int, itemValue reflect.Value) {
+		lock.Lock()
+		defer lock.Unlock()
+		outs := procValue.Call([]reflect.Value{reflect.ValueOf(initValue), itemValue})
+		initValue = outs[0].Interface()
+	})
+	if err := run.Err(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
-	return initValue
+	return initValue, nil
 }
 
 func (p *_Pipe) Some(fn interface{}, atLeast int) bool {
-	length := p.srcLen()
+	p.mustValid()
 	outElemType := p.getOutType()
 	fnValue := reflect.ValueOf(fn)
-	fnType := fnValue.Type()
-	if !isGoodFunc(fnType, []interface{}{outElemType}, []interface{}{reflect.Bool}) {
-		panic("reduce function invalid")
-	}
+	p.mustFunc("Some", fn, []interface{}{outElemType}, []interface{}{reflect.Bool})
 	fulfillNum := 0
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			outs := fnValue.Call([]reflect.Value{itemValue})
-			if outs[0].Bool() {
-				fulfillNum++
-			}
-		})
-		if fulfillNum >= atLeast {
-			return true
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		outs := fnValue.Call([]reflect.Value{itemValue})
+		if outs[0].Bool() {
+			fulfillNum++
 		}
-	}
-	return false
+		return fulfillNum < atLeast
+	})
+	return fulfillNum >= atLeast
 }
 
 func (p *_Pipe) Every(fn interface{}) bool {
-	length := p.srcLen()
+	p.mustValid()
 	outElemType := p.getOutType()
 	fnValue := reflect.ValueOf(fn)
-	fnType := fnValue.Type()
-	if !isGoodFunc(fnType, []interface{}{outElemType}, []interface{}{reflect.Bool}) {
-		panic("reduce function invalid")
-	}
-	for i := 0; i < length; i++ {
-		var isSatisfy bool
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			outs := fnValue.Call([]reflect.Value{itemValue})
-			isSatisfy = outs[0].Bool()
-		})
-		if !isSatisfy {
-			return false
-		}
-	}
-	return true
+	p.mustFunc("Every", fn, []interface{}{outElemType}, []interface{}{reflect.Bool})
+	isSatisfy := true
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		outs := fnValue.Call([]reflect.Value{itemValue})
+		isSatisfy = outs[0].Bool()
+		return isSatisfy
+	})
+	return isSatisfy
 }
 
 type _SortDelegate struct {
@@ -807,53 +884,84 @@ func (s *_SortDelegate) Swap(i, j int) {
 
 func (p *_Pipe) Sort(less interface{}) *_Pipe {
 	lessValue := reflect.ValueOf(less)
-	lessType := lessValue.Type()
+	if p.err != nil {
+		return p.withError(p.err)
+	}
 	outElemType := p.getOutType()
-	if !isGoodFunc(lessType, []interface{}{outElemType, outElemType}, []interface{}{reflect.Bool}) {
-		panic("sort less function invalid")
+	if err := p.checkFunc("sort", less, []interface{}{outElemType, outElemType}, []interface{}{reflect.Bool}); err != nil {
+		return p.withError(err)
+	}
+	arr := reflect.ValueOf(p.ToSlice())
+	if p.srcPipe == nil {
+		// ToSlice returned the source itself, sort a copy of it
+		arr = reflect.AppendSlice(reflect.MakeSlice(arr.Type(), 0, arr.Len()), arr)
 	}
 	delegate := &_SortDelegate{
-		Arr:      reflect.ValueOf(p.ToSlice()),
+		Arr:      arr,
 		lessFunc: lessValue,
 	}
 	sort.Stable(delegate)
 	return &_Pipe{
-		arr: delegate.Arr.Interface(),
+		arr:    delegate.Arr.Interface(),
+		origin: p,
+		originStage: &_StageDesc{
+			Kind:    "sort",
+			InType:  outElemType,
+			OutType: outElemType,
+			Func:    lessValue,
+		},
 	}
 }
 
 func (p *_Pipe) Uniq() *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
 	outElemType := p.getOutType()
 	length := p.srcLen()
-	newSliceType := reflect.SliceOf(outElemType)
-	newSliceValue := reflect.MakeSlice(newSliceType, 0, length)
+	builder := newSliceBuilder(outElemType, length)
 	existsValues := make(map[interface{}]int)
-	for i := 0; i < length; i++ {
-		p.getValue(i).Then(func(itemValue reflect.Value) {
-			val := itemValue.Interface()
-			if _, exists := existsValues[val]; !exists {
-				existsValues[val] = 1
-				newSliceValue = reflect.Append(newSliceValue, itemValue)
-			}
-		})
-	}
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		val := itemValue.Interface()
+		if _, exists := existsValues[val]; !exists {
+			existsValues[val] = 1
+			builder.Append(itemValue)
+		}
+		return true
+	})
 	return &_Pipe{
-		arr: newSliceValue.Interface(),
+		arr:    builder.Result(),
+		origin: p,
+		originStage: &_StageDesc{
+			Kind:    "uniq",
+			InType:  outElemType,
+			OutType: outElemType,
+		},
 	}
 }
 
 func (p *_Pipe) Reverse() *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
 	outElemType := p.getOutType()
 	length := p.srcLen()
-	newSliceType := reflect.SliceOf(outElemType)
-	newSliceValue := reflect.MakeSlice(newSliceType, 0, length)
-	for i := length - 1; i >= 0; i-- {
-		itemValue, keep := p.getValue(i).GetValue()
-		if keep {
-			newSliceValue = reflect.Append(newSliceValue, itemValue)
-		}
+	builder := newSliceBuilder(outElemType, length)
+	var items []reflect.Value
+	p.sRun(func(i int, itemValue reflect.Value) bool {
+		items = append(items, itemValue)
+		return true
+	})
+	for i := len(items) - 1; i >= 0; i-- {
+		builder.Append(items[i])
 	}
 	return &_Pipe{
-		arr: newSliceValue.Interface(),
+		arr:    builder.Result(),
+		origin: p,
+		originStage: &_StageDesc{
+			Kind:    "reverse",
+			InType:  outElemType,
+			OutType: outElemType,
+		},
 	}
 }
@@ -1,8 +1,21 @@
 package pipe
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func intSliceEqual(a []int, b ...int) bool {
@@ -520,3 +533,1259 @@ func BenchmarkPReduce(b *testing.B) {
 	src := []int{N, N, N, N, N}
 	NewPipe(src).Map(longTimeProc).PReduce(0, sumIntReducer)
 }
+
+func TestPlanOptimize(t *testing.T) {
+	src := []int{1, 2, 3, 4, 5, 6}
+	p := NewPipe(src).
+		Map(nil).
+		Filter(func(in int) bool { return in%2 == 0 }).
+		Map(func(in int) int { return in * 10 }).
+		Map(nil)
+	plan := p.getPlan()
+	if len(plan.procs) != 1 {
+		t.Fatal("procs not fused", len(plan.procs))
+	}
+	if fused, ok := plan.procs[0].(*_FusedProc); !ok || len(fused.steps) != 2 {
+		t.Fatal("wrong fused proc", plan.procs[0])
+	}
+	if p.getPlan() != plan {
+		t.Error("plan not cached")
+	}
+	if dst := p.ToSlice().([]int); !intSliceEqual(dst, 20, 40, 60) {
+		t.Error("wrong dst", dst)
+	}
+	if dst := p.PToSlice().([]int); !intSliceEqual(dst, 20, 40, 60) {
+		t.Error("wrong dst", dst)
+	}
+}
+
+func TestFastPath(t *testing.T) {
+	if fastMapStep(func(i int) int { return i }) == nil {
+		t.Error("func(int) int should have a fast path")
+	}
+	if fastFilterStep(func(s string) bool { return s != "" }) == nil {
+		t.Error("func(string) bool should have a fast path")
+	}
+	if fastMapStep(func(u User) int { return u.UserId }) != nil {
+		t.Error("func(User) int should not have a fast path")
+	}
+	dst := NewPipe([]interface{}{1, "a", nil}).
+		Map(func(v interface{}) interface{} { return v }).
+		ToSlice().([]interface{})
+	if len(dst) != 3 || dst[0] != 1 || dst[1] != "a" || dst[2] != nil {
+		t.Error("wrong dst", dst)
+	}
+	strs := NewPipe([]string{"a", "", "b"}).
+		Filter(func(s string) bool { return s != "" }).
+		Map(func(s string) string { return s + s }).
+		ToSlice().([]string)
+	if !strSliceEqual(strs, "aa", "bb") {
+		t.Error("wrong dst", strs)
+	}
+}
+
+var benchSrc = Range1(1000).ToSlice().([]int)
+
+func BenchmarkPlainLoop(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		dst := make([]int, 0, len(benchSrc))
+		for _, v := range benchSrc {
+			if v%3 == 0 {
+				dst = append(dst, v*2)
+			}
+		}
+	}
+}
+
+func BenchmarkToSliceFastPath(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		NewPipe(benchSrc).
+			Filter(func(v int) bool { return v%3 == 0 }).
+			Map(func(v int) int { return v * 2 }).
+			ToSlice()
+	}
+}
+
+type benchInt int
+
+func BenchmarkToSliceReflect(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		NewPipe(benchSrc).
+			Filter(func(v int) bool { return v%3 == 0 }).
+			Map(func(v int) benchInt { return benchInt(v * 2) }).
+			ToSlice()
+	}
+}
+
+func double(i int) int {
+	return i * 2
+}
+
+func TestExplain(t *testing.T) {
+	p := Range(0, 10, 1).
+		Map(double).
+		Filter(func(i int) bool { return i < 10 }).
+		Sort(func(a, b int) bool { return a > b }).
+		Map(func(i int) string { return fmt.Sprint(i) })
+	s := p.Explain()
+	for _, want := range []string{
+		"source: Range(begin=0, end=10, step=1) len 10",
+		"#0 map int -> int ",
+		".double\n",
+		"#1 filter int -> int",
+		"#2 sort int -> int",
+		"#3 map int -> string",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Explain() missing %q:\n%s", want, s)
+		}
+	}
+	if s := NewMapPipe(map[string]int{"a": 1}).Keys().Explain(); !strings.HasPrefix(s, "source: keys of map[string]int len 1") {
+		t.Error("wrong source", s)
+	}
+	dot := p.ExplainDOT()
+	if !strings.HasPrefix(dot, "digraph pipe {") || !strings.Contains(dot, "stage2 -> stage3;") {
+		t.Error("wrong dot", dot)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	src := []int{1, 2, 3}
+	if err := NewPipe(src).Map(double).Validate(); err != nil {
+		t.Error("valid pipe reported error", err)
+	}
+	p := NewPipe(src).
+		Map(double).
+		Map(func(s string) string { return s }).
+		Filter(func(i int) bool { return true })
+	err, ok := p.Validate().(*PipeError)
+	if !ok {
+		t.Fatal("want *PipeError, got", p.Validate())
+	}
+	if err.Stage != 1 || err.Kind != "map" || err.Expected != "func(int) ?" || err.Actual != "func(string) string" {
+		t.Errorf("wrong error %+v", err)
+	}
+	if err := NewPipe(src).Filter(func(i int) int { return i }).Validate(); err == nil || !strings.Contains(err.Error(), "want func(int) bool") {
+		t.Error("wrong filter error", err)
+	}
+	if err := NewPipe(src).Sort(func(a, b string) bool { return a < b }).Validate(); err == nil {
+		t.Error("sort with wrong less func should be invalid")
+	}
+	func() {
+		defer func() {
+			if r, ok := recover().(*PipeError); !ok || r != err {
+				t.Error("terminal should panic with the stage error", r)
+			}
+		}()
+		p.ToSlice()
+	}()
+	func() {
+		defer func() {
+			r, ok := recover().(*PipeError)
+			if !ok || r.Stage != 1 || r.Kind != "ToMap getKey" {
+				t.Errorf("wrong terminal error %+v", r)
+			}
+		}()
+		NewPipe(src).Map(double).ToMap(func(s string) string { return s }, nil)
+	}()
+}
+
+func TestParallelPanic(t *testing.T) {
+	explode := func(i int) int {
+		if i == 3 {
+			panic("boom")
+		}
+		return i
+	}
+	func() {
+		defer func() {
+			err, ok := recover().(*PanicError)
+			if !ok || err.Index != 3 || err.Value != "boom" || len(err.Stack) == 0 {
+				t.Errorf("wrong panic %+v", err)
+			}
+		}()
+		Range(10).Map(explode).PToSlice()
+		t.Error("PToSlice should panic")
+	}()
+	if _, err := Range(10).Map(explode).PToMapE(double, nil); err == nil {
+		t.Error("PToMapE should return the panic")
+	}
+	err := Range(10).PEachE(func(item, index int) {
+		if item == 5 {
+			panic(fmt.Errorf("bad item %d", item))
+		}
+	})
+	if pe, ok := err.(*PanicError); !ok || pe.Index != 5 || pe.Unwrap() == nil {
+		t.Error("wrong PEachE error", err)
+	}
+	if sum, err := Range(10).PReduceE(0, sumIntReducer); err != nil || sum.(int) != 45 {
+		t.Error("wrong PReduceE result", sum, err)
+	}
+}
+
+func TestWaitIndex(t *testing.T) {
+	wait := NewWaitIndex(4, 2)
+	var got []int
+	if !wait.Acquire(0) || !wait.Acquire(1) {
+		t.Fatal("indexes inside the window should be acquired")
+	}
+	acquired := make(chan bool)
+	go func() {
+		acquired <- wait.Acquire(2)
+	}()
+	wait.Done(1, func() { got = append(got, 1) })
+	select {
+	case <-acquired:
+		t.Fatal("index 2 is outside the window until 0 is delivered")
+	default:
+	}
+	if len(got) != 0 {
+		t.Fatal("index 1 delivered before 0")
+	}
+	wait.Done(0, func() { got = append(got, 0) })
+	if !<-acquired {
+		t.Fatal("index 2 should be acquired")
+	}
+	wait.Done(3, func() { got = append(got, 3) })
+	wait.Done(2, nil)
+	if !wait.Wait() || !intSliceEqual(got, 0, 1, 3) {
+		t.Error("wrong delivery", got)
+	}
+
+	wait = NewWaitIndex(-1, 1)
+	go func() {
+		acquired <- wait.Acquire(1)
+	}()
+	wait.Cancel()
+	if <-acquired || wait.Wait() {
+		t.Error("cancelled WaitIndex should release Acquire and Wait")
+	}
+	wait.Done(0, func() { t.Error("delivered after cancel") })
+}
+
+func TestPToSliceWindow(t *testing.T) {
+	dst := Range(100000).
+		Filter(func(i int) bool { return i%7 != 0 }).
+		Map(double).
+		WithWindow(8).
+		PToSlice().([]int)
+	want := Range(100000).Filter(func(i int) bool { return i%7 != 0 }).Map(double).ToSlice().([]int)
+	if !intSliceEqual(dst, want...) {
+		t.Error("PToSlice with a small window lost the order")
+	}
+	groups := Range(1000).WithWindow(3).
+		PToGroupMap(func(i int) int { return i % 3 }, nil).(map[int][]int)
+	for k, vs := range groups {
+		for i, v := range vs {
+			if v != k+3*i {
+				t.Fatal("PToGroupMap lost the order", k, vs)
+			}
+		}
+	}
+}
+
+func TestOrderedExecutor(t *testing.T) {
+	var got []int
+	e := NewOrderedExecutor(context.Background(), 4, func(result interface{}, err error) error {
+		got = append(got, result.(int))
+		return err
+	})
+	for i := 0; i < 20; i++ {
+		i := i
+		err := e.Submit(func(ctx context.Context) (interface{}, error) {
+			// later tasks finish first
+			time.Sleep(time.Duration(20-i) * 100 * time.Microsecond)
+			return i, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !intSliceEqual(got, Range(20).ToSlice().([]int)...) {
+		t.Error("results not in submission order", got)
+	}
+	if err := e.Submit(nil); err != ErrExecutorClosed {
+		t.Error("Submit after Wait should fail", err)
+	}
+}
+
+func TestOrderedExecutorCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	delivered := 0
+	e := NewOrderedExecutor(ctx, 2, func(result interface{}, err error) error {
+		delivered++
+		if result.(int) == 2 {
+			cancel()
+		}
+		return err
+	})
+	var submitErr error
+	for i := 0; i < 100 && submitErr == nil; i++ {
+		i := i
+		submitErr = e.Submit(func(ctx context.Context) (interface{}, error) {
+			return i, nil
+		})
+	}
+	if submitErr != context.Canceled {
+		t.Error("Submit should fail after cancel", submitErr)
+	}
+	if err := e.Wait(); err != context.Canceled {
+		t.Error("Wait should return the cancel error", err)
+	}
+	if delivered != 3 {
+		t.Error("results delivered after cancel", delivered)
+	}
+
+	boom := fmt.Errorf("boom")
+	e = NewOrderedExecutor(context.Background(), 2, func(result interface{}, err error) error {
+		return err
+	})
+	e.Submit(func(ctx context.Context) (interface{}, error) { return nil, boom })
+	e.Submit(func(ctx context.Context) (interface{}, error) { panic("ignored") })
+	if err := e.Wait(); err != boom {
+		t.Error("Wait should return the first task error", err)
+	}
+	e = NewOrderedExecutor(context.Background(), 1, func(result interface{}, err error) error {
+		return err
+	})
+	e.Submit(func(ctx context.Context) (interface{}, error) { panic("boom") })
+	if err, ok := e.Wait().(*PanicError); !ok || err.Value != "boom" {
+		t.Error("Wait should return the task panic", err)
+	}
+}
+
+func TestPMap(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	slowDouble := func(i int) int {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(time.Duration(10-i%10) * 100 * time.Microsecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return i * 2
+	}
+	p := Range(50).
+		Filter(func(i int) bool { return i%5 != 0 }).
+		PMap(slowDouble, 4).
+		PFilter(func(i int) bool { return i%3 != 0 }, 2).
+		Map(func(i int) string { return fmt.Sprint(i) })
+	want := Range(50).
+		Filter(func(i int) bool { return i%5 != 0 }).
+		Map(double).
+		Filter(func(i int) bool { return i%3 != 0 }).
+		Map(func(i int) string { return fmt.Sprint(i) }).
+		ToSlice().([]string)
+	if dst := p.ToSlice().([]string); !strSliceEqual(dst, want...) {
+		t.Error("PMap lost the order", dst)
+	}
+	if maxInFlight > 4 || maxInFlight < 2 {
+		t.Error("wrong concurrency", maxInFlight)
+	}
+	maxInFlight = 0
+	if dst := p.PToSlice().([]string); !strSliceEqual(dst, want...) {
+		t.Error("PMap in PToSlice lost the order", dst)
+	}
+	if maxInFlight > 4 {
+		t.Error("PMap in PToSlice exceeded its workers", maxInFlight)
+	}
+	sum := Range(50).PMap(slowDouble, 8).Unordered().Reduce(0, sumIntReducer).(int)
+	if sum != 2450 {
+		t.Error("wrong unordered sum", sum)
+	}
+	if !Range(1000).PMap(double, 4).Some(func(i int) bool { return i > 10 }, 1) {
+		t.Error("Some should stop early")
+	}
+	if err := Range(5).Map(double).Unordered().Validate(); err == nil {
+		t.Error("Unordered without PMap should be invalid")
+	}
+	if s := Range(5).PMap(double, 3).Unordered().Explain(); !strings.Contains(s, "pmap(3, unordered) int -> int") {
+		t.Error("wrong explain", s)
+	}
+	p = Range(5).PMap(double, 3).WithWindow(4).Unordered()
+	if s := p.Explain(); !strings.Contains(s, "pmap(3, unordered) int -> int") {
+		t.Error("wrong explain after settings", s)
+	}
+	if p.config().window != 4 {
+		t.Error("Unordered lost the settings before it", p.config().window)
+	}
+	func() {
+		defer func() {
+			if err, ok := recover().(*PanicError); !ok || err.Index != 7 {
+				t.Error("wrong panic", err)
+			}
+		}()
+		Range(20).PMap(func(i int) int {
+			if i == 7 {
+				panic("boom")
+			}
+			return i
+		}, 3).ToSlice()
+	}()
+}
+
+func TestExecutors(t *testing.T) {
+	pool := NewPoolExecutor(3)
+	defer pool.Close()
+	want := Range(200).Map(double).ToSlice().([]int)
+	for _, e := range []Executor{GoExecutor, InlineExecutor, pool, SharedExecutor()} {
+		p := Range(200).Map(double).WithExecutor(e)
+		if dst := p.PToSlice().([]int); !intSliceEqual(dst, want...) {
+			t.Errorf("%T: wrong PToSlice", e)
+		}
+		dst := make([]int, 200)
+		p.PEach(func(item, index int) { dst[index] = item })
+		if !intSliceEqual(dst, want...) {
+			t.Errorf("%T: wrong PEach", e)
+		}
+		if sum := p.PReduce(0, sumIntReducer).(int); sum != 39800 {
+			t.Errorf("%T: wrong PReduce %d", e, sum)
+		}
+	}
+	var order []int
+	Range(5).WithExecutor(InlineExecutor).PToMap(func(i int) int {
+		order = append(order, i)
+		return i
+	}, nil)
+	if !intSliceEqual(order, 0, 1, 2, 3, 4) {
+		t.Error("InlineExecutor should run sequentially", order)
+	}
+}
+
+func TestPEachWorker(t *testing.T) {
+	type worker struct {
+		sum   int
+		items int
+	}
+	var mu sync.Mutex
+	var workers []*worker
+	Range(100).PEachWorker(4, func() *worker {
+		return &worker{}
+	}, func(w *worker, item, index int) {
+		if item != index {
+			t.Error("wrong index", item, index)
+		}
+		w.sum += item
+		w.items++
+	}, func(w *worker) {
+		mu.Lock()
+		workers = append(workers, w)
+		mu.Unlock()
+	})
+	sum, items := 0, 0
+	for _, w := range workers {
+		sum += w.sum
+		items += w.items
+	}
+	if len(workers) != 4 || sum != 4950 || items != 100 {
+		t.Error("wrong PEachWorker result", len(workers), sum, items)
+	}
+
+	setups, torndown := 0, 0
+	err := Range(100).PEachWorkerE(3, func() int {
+		mu.Lock()
+		setups++
+		mu.Unlock()
+		return 0
+	}, func(_, item, index int) {
+		if item == 50 {
+			panic("boom")
+		}
+	}, func(int) {
+		mu.Lock()
+		torndown++
+		mu.Unlock()
+	})
+	if pe, ok := err.(*PanicError); !ok || pe.Index != 50 || setups != 3 || torndown != 3 {
+		t.Error("wrong PEachWorkerE error", err, setups, torndown)
+	}
+
+	err = Range(10).PEachWorkerE(2, func() string {
+		panic("no connection")
+	}, func(string, int, int) {}, nil)
+	if pe, ok := err.(*PanicError); !ok || pe.Index != -1 {
+		t.Error("wrong setup error", err)
+	}
+}
+
+func TestPEachByKey(t *testing.T) {
+	type Event struct {
+		Account int
+		Seq     int
+	}
+	var events []Event
+	for i := 0; i < 300; i++ {
+		events = append(events, Event{Account: i % 7, Seq: i})
+	}
+	var mu sync.Mutex
+	last := make(map[int]int)
+	count := 0
+	NewPipe(events).PEachByKey(func(e Event) int { return e.Account }, 4, func(e Event, srcIndex int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if prev, ok := last[e.Account]; ok && prev > e.Seq {
+			t.Error("out of order for account", e.Account, prev, e.Seq)
+		}
+		if srcIndex != e.Seq {
+			t.Error("wrong source index", srcIndex, e.Seq)
+		}
+		last[e.Account] = e.Seq
+		count++
+	})
+	if count != 300 {
+		t.Error("wrong PEachByKey count", count)
+	}
+	// after a Filter the source index is not the output index of PEach
+	var srcIndexes []int
+	Range(10).Filter(func(i int) bool { return i%2 == 0 }).PEachByKey(func(i int) int { return 0 }, 2, func(_, srcIndex int) {
+		srcIndexes = append(srcIndexes, srcIndex)
+	})
+	if !intSliceEqual(srcIndexes, 0, 2, 4, 6, 8) {
+		t.Error("wrong PEachByKey source indexes", srcIndexes)
+	}
+
+	// a stateful stage sees each key in order
+	totals := make([]int, 7)
+	result := NewPipe(events).
+		Map(func(e Event) int {
+			totals[e.Account] += e.Seq
+			return totals[e.Account]
+		}).
+		Filter(func(total int) bool { return total%2 == 0 }).
+		PToSliceByKey(func(e Event) string { return fmt.Sprint("account", e.Account) }, 3).([]int)
+	seqTotals := make([]int, 7)
+	var expected []int
+	for _, e := range events {
+		seqTotals[e.Account] += e.Seq
+		if seqTotals[e.Account]%2 == 0 {
+			expected = append(expected, seqTotals[e.Account])
+		}
+	}
+	if !intSliceEqual(result, expected...) {
+		t.Error("wrong PToSliceByKey result", result)
+	}
+
+	_, err := Range(100).PToSliceByKeyE(func(i int) int {
+		if i == 42 {
+			panic("bad key")
+		}
+		return i
+	}, 4)
+	if pe, ok := err.(*PanicError); !ok || pe.Index != 42 {
+		t.Error("wrong PToSliceByKeyE error", err)
+	}
+}
+
+func TestMapRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[int]int)
+	var waits []time.Duration
+	policy := RetryPolicy{
+		MaxAttempts: 4,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  3 * time.Millisecond,
+		Sleep: func(d time.Duration) {
+			mu.Lock()
+			waits = append(waits, d)
+			mu.Unlock()
+		},
+	}
+	flaky := func(i int) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[i]++
+		if calls[i] <= i%4 {
+			return "", fmt.Errorf("flaky %d", i)
+		}
+		return fmt.Sprint(i), nil
+	}
+	dst := Range(8).MapRetry(flaky, policy).ToSlice().([]string)
+	if !strSliceEqual(dst, "0", "1", "2", "3", "4", "5", "6", "7") {
+		t.Error("wrong MapRetry result", dst)
+	}
+	if fmt.Sprint(waits[:6]) != "[1ms 1ms 2ms 1ms 2ms 3ms]" {
+		t.Error("wrong backoff", waits)
+	}
+
+	calls = make(map[int]int)
+	dst = Range(8).MapRetry(flaky, policy).PToSlice().([]string)
+	if len(dst) != 8 || calls[7] != 4 {
+		t.Error("wrong parallel MapRetry result", dst, calls)
+	}
+
+	permanent := errors.New("permanent")
+	policy.Retryable = func(err error) bool { return err != permanent }
+	_, err := Range(3).MapRetry(func(i int) (int, error) {
+		return 0, permanent
+	}, policy).PToSliceE()
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 || !errors.Is(err, permanent) {
+		t.Error("wrong MapRetry error", err)
+	}
+
+	policy = RetryPolicy{MaxAttempts: 2, AttemptTimeout: time.Millisecond}
+	_, err = Range(1).MapRetry(func(ctx context.Context, i int) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}, policy).PToSliceE()
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 2 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("wrong MapRetry timeout error", err)
+	}
+
+	if err := Range(3).MapRetry(func(i int) int { return i }, policy).Validate(); err == nil {
+		t.Error("MapRetry should need an error result")
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var mu sync.Mutex
+	current, peak := 0, 0
+	enter := func() {
+		mu.Lock()
+		current++
+		if current > peak {
+			peak = current
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		current--
+		mu.Unlock()
+	}
+	p := Range(50).
+		Map(func(i int) int { enter(); return i }).
+		MaxInFlight(3)
+	p.PEach(func(i, index int) { enter() })
+	if peak != 3 {
+		t.Error("wrong PEach peak", peak)
+	}
+	peak = 0
+	if dst := p.PToSlice().([]int); len(dst) != 50 || peak != 3 {
+		t.Error("wrong PToSlice peak", peak)
+	}
+	peak = 0
+	p.PEachByKey(func(i int) int { return i }, 8, func(i, index int) {})
+	if peak != 3 {
+		t.Error("wrong PEachByKey peak", peak)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	slow := func(i int) int {
+		if i == 2 {
+			<-hang
+		}
+		return i * 10
+	}
+	dst := Range(5).Map(slow).Timeout(10*time.Millisecond, TimeoutDrop).PToSlice().([]int)
+	if !intSliceEqual(dst, 0, 10, 30, 40) {
+		t.Error("wrong dropped result", dst)
+	}
+	dst = Range(5).Map(slow).Timeout(10*time.Millisecond, TimeoutFallback(-1)).ToSlice().([]int)
+	if !intSliceEqual(dst, 0, 10, -1, 30, 40) {
+		t.Error("wrong fallback result", dst)
+	}
+	dst = Range(5).PMap(slow, 2).Timeout(10*time.Millisecond, TimeoutFallback(nil)).ToSlice().([]int)
+	if !intSliceEqual(dst, 0, 10, 0, 30, 40) {
+		t.Error("wrong pmap fallback result", dst)
+	}
+	_, err := Range(5).Map(slow).Timeout(10*time.Millisecond, TimeoutFail).PToSliceE()
+	if pe, ok := err.(*PanicError); !ok || pe.Index != 2 || !errors.Is(err, ErrTimeout) {
+		t.Error("wrong timeout error", err)
+	}
+	if err := Range(5).Map(slow).Timeout(time.Second, TimeoutFallback("x")).Validate(); err == nil {
+		t.Error("fallback of the wrong type should be invalid")
+	}
+	if err := Range(5).Timeout(time.Second, TimeoutDrop).Validate(); err == nil {
+		t.Error("timeout needs a preceding stage")
+	}
+	if !strings.Contains(Range(5).Map(slow).Timeout(time.Second, TimeoutDrop).Explain(), "map timeout(1s, drop) int -> int") {
+		t.Error("wrong timeout explain")
+	}
+	// settings between the stage and Timeout are kept
+	var letters []DeadLetter
+	dst = Range(5).Map(slow).WithDeadLetters(NewDeadLetters(&letters)).Timeout(10*time.Millisecond, TimeoutFail).ToSlice().([]int)
+	if !intSliceEqual(dst, 0, 10, 30, 40) || len(letters) != 1 || letters[0].Index != 2 {
+		t.Error("wrong timeout after settings", dst, letters)
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	slow := func(i int) int {
+		if i == 3 {
+			<-hang
+		}
+		return i
+	}
+	p := Range(10).Map(slow).WithDeadline(time.Now().Add(20 * time.Millisecond))
+	start := time.Now()
+	if _, err := p.PToSliceE(); err != ErrDeadline {
+		t.Error("wrong PToSliceE deadline error", err)
+	}
+	if err := p.PEachE(func(i, index int) {}); err != ErrDeadline {
+		t.Error("wrong PEachE deadline error", err)
+	}
+	if _, err := p.PToMapE(func(i int) int { return i }, nil); err != ErrDeadline {
+		t.Error("wrong PToMapE deadline error", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("deadline did not stop the run in time")
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrDeadline {
+				t.Error("wrong sequential deadline panic", r)
+			}
+		}()
+		Range(10).Map(func(i int) int {
+			time.Sleep(5 * time.Millisecond)
+			return i
+		}).WithDeadline(time.Now().Add(20 * time.Millisecond)).ToSlice()
+	}()
+	// the deadline is also checked while the stages drop every element
+	slowFalse := func(i int) bool {
+		time.Sleep(5 * time.Millisecond)
+		return false
+	}
+	for name, p := range map[string]*_Pipe{
+		"sequential": Range(40).Filter(slowFalse),
+		"streamed":   Range(40).PFilter(slowFalse, 1),
+	} {
+		start := time.Now()
+		func() {
+			defer func() {
+				if r := recover(); r != ErrDeadline {
+					t.Error("wrong deadline panic with dropped elements", name, r)
+				}
+			}()
+			p.WithDeadline(start.Add(20 * time.Millisecond)).ToSlice()
+		}()
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Error("deadline checked too late", name, d)
+		}
+	}
+	dst := Range(10).WithDeadline(time.Now().Add(time.Hour)).Map(func(i int) int { return i }).PToSlice().([]int)
+	if len(dst) != 10 {
+		t.Error("wrong result before the deadline", dst)
+	}
+}
+
+type countingClock struct {
+	calls int
+}
+
+func (c *countingClock) Now() time.Time {
+	c.calls++
+	return time.Now()
+}
+
+func (c *countingClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	p := Range(5).Map(double)
+	if err := p.CircuitBreaker(BreakerPolicy{Fallback: func(s string) int { return 0 }}).Validate(); err == nil {
+		t.Error("fallback of the wrong type should be invalid")
+	}
+	if err := Range(5).CircuitBreaker(BreakerPolicy{}).Validate(); err == nil {
+		t.Error("breaker needs a preceding stage")
+	}
+	dst := p.PMap(func(i int) int {
+		if i > 4 {
+			panic("too big")
+		}
+		return i
+	}, 2).CircuitBreaker(BreakerPolicy{Fallback: func(i int) int { return -i }}).PToSlice().([]int)
+	if !intSliceEqual(dst, 0, 2, 4, -6, -8) {
+		t.Error("wrong breaker fallback result", dst)
+	}
+	// settings between the stage and CircuitBreaker are kept
+	clock := &countingClock{}
+	dst = Range(5).Map(func(i int) int {
+		if i == 1 {
+			panic("one")
+		}
+		return i
+	}).WithClock(clock).CircuitBreaker(BreakerPolicy{Window: 1, Cooldown: time.Hour, Fallback: func(i int) int { return -1 }}).ToSlice().([]int)
+	if !intSliceEqual(dst, 0, -1, -1, -1, -1) || clock.calls == 0 {
+		t.Error("wrong breaker result after settings", dst, clock.calls)
+	}
+	if err := p.WithClock(clock).CircuitBreaker(BreakerPolicy{Fallback: func(s string) int { return 0 }}).Validate(); err == nil {
+		t.Error("fallback of the wrong type should be invalid after settings")
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	var letters []DeadLetter
+	dead := NewDeadLetters(&letters)
+	parse := func(s string) int {
+		var i int
+		if _, err := fmt.Sscan(s, &i); err != nil {
+			panic(err)
+		}
+		return i
+	}
+	p := NewPipe([]string{"1", "x", "3", "-4", "5", "6"}).
+		Map(parse).
+		Require(func(i int) error {
+			if i < 0 {
+				return fmt.Errorf("negative %d", i)
+			}
+			return nil
+		}).
+		Filter(func(i int) bool { return i%2 == 1 }).
+		WithDeadLetters(dead)
+	if dst := p.ToSlice().([]int); !intSliceEqual(dst, 1, 3, 5) {
+		t.Error("wrong result with dead letters", dst)
+	}
+	if len(letters) != 2 || letters[0].Index != 1 || letters[0].Value != "x" || letters[1].Index != 3 || letters[1].Value != -4 {
+		t.Fatal("wrong dead letters", letters)
+	}
+	if !strings.HasPrefix(letters[0].Stage, "#0 map string -> int") || !strings.HasPrefix(letters[1].Stage, "#1 require int -> int") {
+		t.Error("wrong dead letter stages", letters[0].Stage, letters[1].Stage)
+	}
+	if _, ok := letters[0].Err.(*PanicError); !ok || letters[1].Err.Error() != "negative -4" {
+		t.Error("wrong dead letter errors", letters[0].Err, letters[1].Err)
+	}
+	summary := dead.Summary()
+	if len(summary) != 3 || summary[0].Failed != 1 || summary[1].Failed != 1 || summary[2].Dropped != 1 {
+		t.Error("wrong summary", summary)
+	}
+
+	// parallel terminals and stages go on too
+	ch := make(chan DeadLetter, 10)
+	dst := Range(10).PMap(func(i int) int {
+		if i%3 == 0 {
+			panic("multiple of 3")
+		}
+		return i
+	}, 3).WithDeadLetters(NewDeadLetters(ch)).PToSlice().([]int)
+	if !intSliceEqual(dst, 1, 2, 4, 5, 7, 8) || len(ch) != 4 {
+		t.Error("wrong parallel dead letters", dst, len(ch))
+	}
+	var mu sync.Mutex
+	count := 0
+	dst = Range(10).PMap(func(i int) int {
+		if i%3 == 0 {
+			panic("multiple of 3")
+		}
+		return i
+	}, 3).WithDeadLetters(NewDeadLetters(func(DeadLetter) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})).ToSlice().([]int)
+	if !intSliceEqual(dst, 1, 2, 4, 5, 7, 8) || count != 4 {
+		t.Error("wrong streamed dead letters", dst, count)
+	}
+
+	// without dead letters Require only filters
+	if dst := Range(4).Require(func(i int) error {
+		if i == 2 {
+			return errors.New("two")
+		}
+		return nil
+	}).ToSlice().([]int); !intSliceEqual(dst, 0, 1, 3) {
+		t.Error("wrong Require result", dst)
+	}
+}
+
+type recordHook struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *recordHook) add(event string) {
+	h.mu.Lock()
+	h.events = append(h.events, event)
+	h.mu.Unlock()
+}
+
+func (h *recordHook) OnStart(total int) { h.add(fmt.Sprint("start ", total)) }
+
+func (h *recordHook) OnItem(stage string, in, out interface{}, d time.Duration) {
+	h.add(fmt.Sprint("item ", stage[:2], " ", in, "->", out))
+}
+
+func (h *recordHook) OnDrop(stage string, in interface{}, d time.Duration) {
+	h.add(fmt.Sprint("drop ", stage[:2], " ", in))
+}
+
+func (h *recordHook) OnFinish(err error) { h.add(fmt.Sprint("finish ", err)) }
+
+func TestHooks(t *testing.T) {
+	hook := &recordHook{}
+	Range(3).Map(double).Filter(func(i int) bool { return i > 0 }).WithHook(hook).ToSlice()
+	expected := "[start 3 item #0 0->0 drop #1 0 item #0 1->2 item #1 2->2 item #0 2->4 item #1 4->4 finish <nil>]"
+	if fmt.Sprint(hook.events) != expected {
+		t.Error("wrong hook events", hook.events)
+	}
+
+	stats := NewStatsCollector()
+	var progress []string
+	p := Range(100).
+		Map(double).
+		Filter(func(i int) bool { return i%3 == 0 }).
+		WithHook(stats).
+		Progress(func(done, total int) {
+			progress = append(progress, fmt.Sprint(done, "/", total))
+		})
+	p.PEach(func(i, index int) {})
+	s := stats.Stages()
+	if len(s) != 2 || s[0].In != 100 || s[0].Out != 100 || s[1].In != 100 || s[1].Out != 34 || s[1].Dropped != 66 {
+		t.Fatal("wrong stats", s)
+	}
+	if rate := s[1].PassRate(); rate != 0.34 {
+		t.Error("wrong pass rate", rate)
+	}
+	latencies := 0
+	for _, n := range s[0].Latency {
+		latencies += n
+	}
+	if latencies != 100 || !strings.Contains(stats.String(), "pass 34.0%") {
+		t.Error("wrong latency histogram", s[0].Latency, stats)
+	}
+	if len(progress) != 100 || progress[0] != "1/100" || progress[99] != "100/100" {
+		t.Error("wrong progress", len(progress), progress)
+	}
+
+	hook = &recordHook{}
+	_, err := Range(3).Map(func(i int) int { panic("boom") }).WithHook(hook).PToSliceE()
+	if last := hook.events[len(hook.events)-1]; err == nil || !strings.HasPrefix(last, "finish pipe: panic") {
+		t.Error("wrong finish event", last)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	long := strings.Repeat("x", 100)
+	Range(3).
+		Map(func(i int) string { return fmt.Sprint(i, long) }).
+		Filter(func(s string) bool { return s[0] != '1' }).
+		WithLogger(logger, slog.LevelInfo).
+		ToSlice()
+	out := buf.String()
+	for _, expected := range []string{
+		`level=INFO msg="pipe start" source="Range(begin=0, end=3, step=1) len 3" stages="[#0 map int -> string`,
+		`level=DEBUG msg="pipe item" index=2 stage="#0 map int -> string`,
+		`level=DEBUG msg="pipe drop" index=1 stage="#1 filter string -> string`,
+		`out=0` + strings.Repeat("x", 63) + `...`,
+		`level=INFO msg="pipe finish"`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("log does not contain %s:\n%s", expected, out)
+		}
+	}
+
+	buf.Reset()
+	Range(3).Map(func(i int) int {
+		if i == 1 {
+			panic("boom")
+		}
+		return i
+	}).WithLogger(logger, slog.LevelInfo).WithDeadLetters(NewDeadLetters(func(DeadLetter) {})).PToSlice()
+	if out := buf.String(); !strings.Contains(out, `level=ERROR msg="pipe element failed" index=1`) {
+		t.Error("recovered panic not logged", out)
+	}
+	buf.Reset()
+	Range(3).Map(func(i int) int { panic("boom") }).WithLogger(logger, slog.LevelInfo).PToSliceE()
+	if out := buf.String(); !strings.Contains(out, `level=ERROR msg="pipe failed"`) {
+		t.Error("failure not logged", out)
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	m := NewMetrics()
+	dead := NewDeadLetters(func(DeadLetter) {})
+	p := Range(10).
+		Map(func(i int) int {
+			if i == 7 {
+				panic("boom")
+			}
+			return i
+		}).
+		Filter(func(i int) bool { return i%2 == 0 }).
+		WithDeadLetters(dead).
+		WithMetrics(m, `jobs "a"`)
+	p.ToSlice()
+	p.PToSlice()
+	Range(3).Map(func(i int) int { panic("boom") }).WithMetrics(m, "broken").PToSliceE()
+
+	pipelines := m.Pipelines()
+	if len(pipelines) != 2 || pipelines[0].Name != "broken" {
+		t.Fatal("wrong pipelines", pipelines)
+	}
+	jobs := pipelines[1]
+	if jobs.Runs != 2 || jobs.RunsInFlight != 0 || jobs.In != 20 || jobs.Out != 10 ||
+		jobs.Dropped != 8 || jobs.Errors != 2 || jobs.InFlight != 0 || len(jobs.Stages) != 2 {
+		t.Errorf("wrong metrics %+v", jobs)
+	}
+	if s := jobs.Stages[1]; s.In != 18 || s.Out != 10 {
+		t.Errorf("wrong stage metrics %+v", s)
+	}
+	if broken := pipelines[0]; broken.Runs != 1 || broken.Errors != 1 || broken.In == 0 || broken.InFlight != 0 {
+		t.Errorf("wrong failed metrics %+v", broken)
+	}
+
+	var v expvar.Var = m
+	var vars map[string]PipelineMetrics
+	if err := json.Unmarshal([]byte(v.String()), &vars); err != nil || vars[`jobs "a"`].Out != 10 {
+		t.Error("wrong expvar", vars, err)
+	}
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	out := string(body)
+	for _, expected := range []string{
+		"# TYPE pipe_items_in_total counter\n",
+		`pipe_items_in_total{pipeline="jobs \"a\""} 20`,
+		`pipe_items_dropped_total{pipeline="jobs \"a\""} 8`,
+		`pipe_errors_total{pipeline="broken"} 1`,
+		`pipe_items_in_flight{pipeline="jobs \"a\""} 0`,
+		`pipe_items_in_flight{pipeline="broken"} 0`,
+		"# TYPE pipe_stage_duration_seconds histogram\n",
+		`pipe_stage_duration_seconds_bucket{pipeline="jobs \"a\"",stage="#1 filter int -> int`,
+		`le="+Inf"} 18`,
+		`pipe_stage_duration_seconds_count{pipeline="jobs \"a\"",stage="#1 filter int -> int`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("metrics do not contain %s:\n%s", expected, out)
+		}
+	}
+}
+
+func TestTap(t *testing.T) {
+	var seen []int
+	result := Range(6).
+		Filter(func(i int) bool { return i%2 == 1 }).
+		Tap(func(i int) { seen = append(seen, i) }).
+		Map(func(i int) int { return i * 10 }).
+		ToSlice().([]int)
+	if !intSliceEqual(result, 10, 30, 50) || !intSliceEqual(seen, 1, 3, 5) {
+		t.Error("wrong tap", result, seen)
+	}
+
+	var mu sync.Mutex
+	indexes := make(map[int]int)
+	p := Range(6).
+		Map(func(i int) int { return i * i }).
+		Filter(func(i int) bool { return i%2 == 0 }).
+		TapIndexed(func(v, index int) {
+			mu.Lock()
+			indexes[index] = v
+			mu.Unlock()
+		})
+	if result := p.PToSlice().([]int); !intSliceEqual(result, 0, 4, 16) {
+		t.Error("wrong parallel tap result", result)
+	}
+	if fmt.Sprint(indexes) != "map[0:0 2:4 4:16]" {
+		t.Error("wrong tap indexes", indexes)
+	}
+	indexes = make(map[int]int)
+	if result := p.PMap(func(i int) int { return i }, 3).ToSlice().([]int); !intSliceEqual(result, 0, 4, 16) || len(indexes) != 3 {
+		t.Error("wrong streamed tap", result, indexes)
+	}
+
+	called := false
+	disabled := Range(3).Tap(func(int) { called = true }).WithTaps(false)
+	if result := disabled.ToSlice().([]int); !intSliceEqual(result, 0, 1, 2) || called {
+		t.Error("disabled tap was called", result)
+	}
+	if len(disabled.getPlan().procs) != 0 {
+		t.Error("disabled tap left in the plan", disabled.getPlan().procs)
+	}
+	// an empty plan still leaves the source alone
+	src := []int{3, 1, 2}
+	NewPipe(src).Tap(func(int) {}).WithTaps(false).Sort(func(a, b int) bool { return a < b })
+	NewPipe(src).Tap(func(int) {}).WithTaps(false).ToSlice().([]int)[0] = 9
+	if !intSliceEqual(src, 3, 1, 2) {
+		t.Error("disabled tap changed the source", src)
+	}
+	if !strings.Contains(Range(3).Tap(func(int) {}).Explain(), "tap int -> int") {
+		t.Error("tap not explained")
+	}
+	if err := Range(3).Tap(func(int) int { return 0 }).Validate(); err == nil {
+		t.Error("tap returning a value accepted")
+	}
+}
+
+func TestIndexedStages(t *testing.T) {
+	words := []string{"a", "bb", "ccc", "dd", "e", "ff", "ggg"}
+	p := NewPipe(words).
+		Filter(func(s string) bool { return len(s) > 1 }).
+		FilterIndexed(func(s string, i int) bool { return i%3 != 0 }).
+		MapIndexed(func(s string, i int) string { return fmt.Sprint(i, s) })
+	if result := p.ToSlice().([]string); fmt.Sprint(result) != "[1bb 2ccc 5ff]" {
+		t.Error("wrong indexed stages", result)
+	}
+	if result := p.PToSlice().([]string); fmt.Sprint(result) != "[1bb 2ccc 5ff]" {
+		t.Error("wrong parallel indexed stages", result)
+	}
+
+	rows := NewPipe(words).Filter(func(s string) bool { return len(s) > 1 }).WithIndex().ToSlice().([]Indexed)
+	if fmt.Sprint(rows) != "[{1 bb} {2 ccc} {3 dd} {5 ff} {6 ggg}]" {
+		t.Error("wrong WithIndex", rows)
+	}
+	sorted := NewPipe(words).WithIndex().
+		PMap(func(r Indexed) Indexed { return r }, 2).
+		Sort(func(a, b Indexed) bool {
+			return len(a.Value.(string)) > len(b.Value.(string)) || len(a.Value.(string)) == len(b.Value.(string)) && a.Index < b.Index
+		}).
+		Map(func(r Indexed) int { return r.Index }).
+		ToSlice().([]int)
+	if !intSliceEqual(sorted, 2, 6, 1, 3, 5, 0, 4) {
+		t.Error("wrong row numbers after sort", sorted)
+	}
+	if err := NewPipe(words).MapIndexed(func(s string) string { return s }).Validate(); err == nil {
+		t.Error("MapIndexed without index accepted")
+	}
+	if !strings.Contains(NewPipe(words).WithIndex().Explain(), "withindex string -> pipe.Indexed") {
+		t.Error("WithIndex not explained", NewPipe(words).WithIndex().Explain())
+	}
+}
+
+func TestScan(t *testing.T) {
+	sum := func(acc, i int) int { return acc + i }
+	p := Range(1, 8).Filter(func(i int) bool { return i != 4 }).Scan(0, sum)
+	if result := p.ToSlice().([]int); !intSliceEqual(result, 1, 3, 6, 11, 17, 24) {
+		t.Error("wrong scan", result)
+	}
+	// every run starts again from init
+	if result := p.ToSlice().([]int); !intSliceEqual(result, 1, 3, 6, 11, 17, 24) {
+		t.Error("wrong second scan", result)
+	}
+	slow := Range(20).
+		Map(func(i int) int {
+			time.Sleep(time.Duration(20-i) * 100 * time.Microsecond)
+			return i
+		}).
+		Scan(nil, func(max, i int) int {
+			if i%7 > max {
+				return i % 7
+			}
+			return max
+		}).
+		MapIndexed(func(max, index int) string { return fmt.Sprint(index, ":", max) })
+	result, err := slow.PToSliceE()
+	if err != nil || fmt.Sprint(result) != "[0:0 1:1 2:2 3:3 4:4 5:5 6:6 7:6 8:6 9:6 10:6 11:6 12:6 13:6 14:6 15:6 16:6 17:6 18:6 19:6]" {
+		t.Error("wrong parallel scan", result, err)
+	}
+	if result := p.PMap(func(i int) int { return i * 2 }, 3).ToSlice().([]int); !intSliceEqual(result, 2, 6, 12, 22, 34, 48) {
+		t.Error("wrong streamed scan", result)
+	}
+
+	// a state machine counting runs of equal elements
+	type run struct{ value, count int }
+	runs := NewPipe([]int{1, 1, 2, 2, 2, 1}).
+		Scan(run{}, func(r run, i int) run {
+			if r.count > 0 && r.value == i {
+				return run{i, r.count + 1}
+			}
+			return run{i, 1}
+		}).
+		Map(func(r run) int { return r.count }).
+		ToSlice().([]int)
+	if !intSliceEqual(runs, 1, 2, 1, 2, 3, 1) {
+		t.Error("wrong scan state machine", runs)
+	}
+
+	if err := p.PEachE(func(int, int) {}); err != nil {
+		t.Error("ordered PEach failed", err)
+	}
+	if _, err := p.PReduceE(0, sum); err != ErrUnorderedScan {
+		t.Error("unordered terminal accepted", err)
+	}
+	if _, err := p.PToSliceByKeyE(func(i int) int { return i }, 2); err != ErrUnorderedScan {
+		t.Error("ByKey terminal accepted", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrUnorderedScan {
+				t.Error("Unordered stage before Scan accepted", r)
+			}
+		}()
+		Range(5).PMap(func(i int) int { return i }, 2).Unordered().Scan(0, sum).ToSlice()
+	}()
+	if err := Range(5).Scan("", sum).Validate(); err == nil {
+		t.Error("wrong init accepted")
+	}
+	if err := Range(5).Scan(0, sum).Timeout(time.Second, TimeoutDrop).Validate(); err == nil {
+		t.Error("Timeout of Scan accepted")
+	}
+}
+
+func TestIndexedStagesWrapped(t *testing.T) {
+	index := func(v, i int) int { return i }
+	odd := func(v, i int) bool { return i%2 == 1 }
+	for name, p := range map[string]*_Pipe{
+		"timeout":        Range(5).MapIndexed(index).Timeout(time.Second, TimeoutFail),
+		"breaker":        Range(5).MapIndexed(index).CircuitBreaker(BreakerPolicy{}),
+		"pmap timeout":   Range(5).Map(func(i int) int { return i }).PMap(func(i int) int { return i }, 2).FilterIndexed(odd).Timeout(time.Second, TimeoutFail).MapIndexed(index),
+		"filter breaker": Range(5).FilterIndexed(odd).CircuitBreaker(BreakerPolicy{}).MapIndexed(index),
+	} {
+		expected := "[0 1 2 3 4]"
+		if strings.Contains(name, " ") {
+			expected = "[1 3]"
+		}
+		if result := p.ToSlice(); fmt.Sprint(result) != expected {
+			t.Error("wrong index under", name, result)
+		}
+		if result := p.PToSlice(); fmt.Sprint(result) != expected {
+			t.Error("wrong parallel index under", name, result)
+		}
+	}
+	var indexes []int
+	Range(3).TapIndexed(func(v, i int) { indexes = append(indexes, i) }).Timeout(time.Second, TimeoutFail).ToSlice()
+	if !intSliceEqual(indexes, 0, 1, 2) {
+		t.Error("wrong tap index under timeout", indexes)
+	}
+}
+
+func TestObservedPlanAfterSort(t *testing.T) {
+	p := Range(3).
+		Map(func(i int) string { return fmt.Sprint(i) }).
+		Sort(func(a, b string) bool { return a > b }).
+		Map(func(s string) string { return s + "!" }).
+		WithHook(NewStatsCollector())
+	start, ok := p.getPlan().procs[0].(*_StartProc)
+	if !ok || start.GetOutType() != reflect.TypeOf("") {
+		t.Error("wrong start proc", p.getPlan().procs[0])
+	}
+	if result := p.ToSlice().([]string); fmt.Sprint(result) != "[2! 1! 0!]" {
+		t.Error("wrong observed result after Sort", result)
+	}
+}
+
+func TestSourceNotAliased(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+	src := []int{3, 1, 2}
+	if sorted := NewPipe(src).Map(nil).Sort(less).ToSlice().([]int); !intSliceEqual(sorted, 1, 2, 3) {
+		t.Error("wrong sort", sorted)
+	}
+	NewPipe(src).Sort(less)
+	NewPipe(src).WithWindow(4).ToSlice().([]int)[0] = 9
+	NewPipe(src).Map(nil).ToSlice().([]int)[1] = 9
+	if !intSliceEqual(src, 3, 1, 2) {
+		t.Error("source changed", src)
+	}
+}
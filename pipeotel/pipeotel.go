@@ -0,0 +1,73 @@
+// Package pipeotel lets pipes report their runs to OpenTelemetry:
+//
+//	p.WithTracer(ctx, pipeotel.New(otel.Tracer("jobs")), pipe.TraceStages())
+//
+// It is built against go.opentelemetry.io/otel and otel/trace v1.44.0 and
+// tested with otel/sdk v1.44.0.
+package pipeotel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lennon-guan/pipe"
+)
+
+// Tracer is a pipe.Tracer starting its spans with an OpenTelemetry tracer.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+func New(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+func (t *Tracer) StartSpan(ctx context.Context, name string) (context.Context, pipe.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, _Span{span}
+}
+
+type _Span struct {
+	span trace.Span
+}
+
+// SetAttr sets an attribute of the span. The "error" attribute set by
+// failed terminals also marks the span as failed.
+func (s _Span) SetAttr(key string, value interface{}) {
+	if key == "error" {
+		s.span.SetStatus(codes.Error, fmt.Sprint(value))
+	}
+	s.span.SetAttributes(keyValue(key, value))
+}
+
+func (s _Span) End() {
+	s.span.End()
+}
+
+func keyValue(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case string:
+		return attribute.String(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	case time.Duration:
+		return attribute.String(key, v.String())
+	case fmt.Stringer:
+		return attribute.Stringer(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
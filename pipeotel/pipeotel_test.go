@@ -0,0 +1,100 @@
+package pipeotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/lennon-guan/pipe"
+)
+
+func newTracer() (*Tracer, *tracetest.SpanRecorder) {
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	return New(provider.Tracer("pipeotel_test")), rec
+}
+
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestWithTracer(t *testing.T) {
+	tracer, rec := newTracer()
+	pipe.Range(4).Filter(func(i int) bool { return i != 2 }).
+		WithTracer(context.Background(), tracer, pipe.TraceStages()).
+		ToSlice()
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("wrong spans %v", spans)
+	}
+	stage, root := spans[0], spans[1]
+	if root.Name() != "pipe" || root.Parent().IsValid() || stage.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Error("wrong span tree", root.Name(), stage.Name())
+	}
+	if a := attrs(root); a["pipe.total"].AsInt64() != 4 || a["pipe.source"].Type() != attribute.STRING {
+		t.Error("wrong terminal attributes", a)
+	}
+	if a := attrs(stage); a["pipe.stage.in"].AsInt64() != 4 || a["pipe.stage.out"].AsInt64() != 3 {
+		t.Error("wrong stage attributes", a)
+	}
+	if root.Status().Code == codes.Error {
+		t.Error("successful run marked as failed", root.Status())
+	}
+}
+
+func TestFailedRun(t *testing.T) {
+	tracer, rec := newTracer()
+	_, err := pipe.Range(3).Map(func(i int) int { panic("boom") }).
+		WithTracer(context.Background(), tracer).
+		PToSliceE()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("wrong spans %v", spans)
+	}
+	if status := spans[0].Status(); status.Code != codes.Error || status.Description != err.Error() {
+		t.Error("wrong status", status)
+	}
+	if a := attrs(spans[0]); a["error"].AsString() != err.Error() {
+		t.Error("wrong error attribute", a)
+	}
+}
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+func TestKeyValue(t *testing.T) {
+	for _, c := range []struct {
+		value    interface{}
+		expected attribute.Value
+	}{
+		{true, attribute.BoolValue(true)},
+		{3, attribute.IntValue(3)},
+		{int64(4), attribute.Int64Value(4)},
+		{1.5, attribute.Float64Value(1.5)},
+		{"s", attribute.StringValue("s")},
+		{[]string{"a", "b"}, attribute.StringSliceValue([]string{"a", "b"})},
+		{2 * time.Second, attribute.StringValue("2s")},
+		{stringer{}, attribute.StringValue("stringer")},
+		{errors.New("e"), attribute.StringValue("e")},
+		{[]int{1}, attribute.StringValue("[1]")},
+	} {
+		kv := keyValue("k", c.value)
+		if kv.Key != "k" || kv.Value != c.expected {
+			t.Errorf("wrong attribute for %#v: %v", c.value, kv.Value.Emit())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package pipetest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a pipe.Clock whose time only moves when Sleep or Advance is
+// called. Sleep returns at once after moving the time forward, so the
+// time-based stages of a pipe run without real waiting.
+type Clock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+// NewClock returns a Clock starting at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep moves the time forward by d.
+func (c *Clock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slept += d
+	c.now = c.now.Add(d)
+}
+
+// Advance moves the time forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Slept returns the total time all callers of Sleep have asked to wait.
+func (c *Clock) Slept() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slept
+}
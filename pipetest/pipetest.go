@@ -0,0 +1,99 @@
+// Package pipetest helps testing code that uses the P* terminals of pipe
+// by making the order their tasks run in reproducible.
+package pipetest
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/lennon-guan/pipe"
+)
+
+// Scheduler is a pipe.BatchExecutor that runs the queued tasks one at a
+// time on the goroutine calling Flush, in an order chosen by its pick
+// function. Tasks are numbered in submission order starting from 0; for a
+// P* terminal whose window covers the whole source, task i computes source
+// element i.
+type Scheduler struct {
+	queue []_Task
+	next  int
+	pick  func(queue []int) int
+}
+
+type _Task struct {
+	seq int
+	fn  func()
+}
+
+// NewSeededScheduler returns a Scheduler picking the next task at random
+// from a source seeded with seed.
+func NewSeededScheduler(seed int64) *Scheduler {
+	rnd := rand.New(rand.NewSource(seed))
+	return &Scheduler{
+		pick: func(queue []int) int {
+			return rnd.Intn(len(queue))
+		},
+	}
+}
+
+// NewOrderScheduler returns a Scheduler running the tasks numbered in order
+// first, each as soon as it is queued, and every other task in submission
+// order.
+func NewOrderScheduler(order ...int) *Scheduler {
+	return &Scheduler{
+		pick: func(queue []int) int {
+			for len(order) > 0 {
+				for i, seq := range queue {
+					if seq == order[0] {
+						order = order[1:]
+						return i
+					}
+				}
+				if order[0] >= queue[len(queue)-1] {
+					break
+				}
+				// already run or never submitted
+				order = order[1:]
+			}
+			return 0
+		},
+	}
+}
+
+// Submit queues task.
+func (s *Scheduler) Submit(task func()) {
+	s.queue = append(s.queue, _Task{seq: s.next, fn: task})
+	s.next++
+}
+
+// Flush runs every queued task, including the ones they submit.
+func (s *Scheduler) Flush() {
+	for len(s.queue) > 0 {
+		seqs := make([]int, len(s.queue))
+		for i, task := range s.queue {
+			seqs[i] = task.seq
+		}
+		i := s.pick(seqs)
+		task := s.queue[i]
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		task.fn()
+	}
+}
+
+// RunSeeds calls run once with a seeded Scheduler for each of the seeds 0
+// to n-1 and reports an error if any result differs from the one of seed 0.
+// run is expected to execute a pipe using the executor it is given.
+func RunSeeds(t testing.TB, n int, run func(e pipe.Executor) interface{}) {
+	t.Helper()
+	var first interface{}
+	for seed := 0; seed < n; seed++ {
+		result := run(NewSeededScheduler(int64(seed)))
+		if seed == 0 {
+			first = result
+		} else if !reflect.DeepEqual(first, result) {
+			t.Errorf("pipetest: result under seed %d differs from seed 0: %v != %v", seed, result, first)
+			return
+		}
+	}
+}
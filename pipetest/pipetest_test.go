@@ -0,0 +1,187 @@
+package pipetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lennon-guan/pipe"
+)
+
+func concat(s string, i int) string {
+	return s + fmt.Sprint(i)
+}
+
+func TestSeededScheduler(t *testing.T) {
+	results := make(map[string]bool)
+	for seed := int64(0); seed < 20; seed++ {
+		s := pipe.Range(6).WithExecutor(NewSeededScheduler(seed)).PReduce("", concat).(string)
+		again := pipe.Range(6).WithExecutor(NewSeededScheduler(seed)).PReduce("", concat).(string)
+		if s != again {
+			t.Fatal("same seed gave different orders", s, again)
+		}
+		results[s] = true
+	}
+	if len(results) < 2 {
+		t.Error("seeds should produce different completion orders", results)
+	}
+}
+
+func TestOrderScheduler(t *testing.T) {
+	s := pipe.Range(5).WithExecutor(NewOrderScheduler(3, 1)).PReduce("", concat).(string)
+	if s != "31024" {
+		t.Error("wrong completion order", s)
+	}
+	var calls []int
+	pipe.Range(3).WithExecutor(NewOrderScheduler(2, 1, 0, 5, 4, 3)).PEach(func(item, index int) {
+		calls = append(calls, item)
+	})
+	if fmt.Sprint(calls) != "[2 1 0]" {
+		t.Error("wrong PEach call order", calls)
+	}
+}
+
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failed = true
+}
+
+func TestRunSeeds(t *testing.T) {
+	RunSeeds(t, 10, func(e pipe.Executor) interface{} {
+		return pipe.Range(20).
+			Map(func(i int) int { return i * i }).
+			WithExecutor(e).
+			PToSlice()
+	})
+	r := &recorder{}
+	RunSeeds(r, 10, func(e pipe.Executor) interface{} {
+		return pipe.Range(6).WithExecutor(e).PReduce("", concat)
+	})
+	if !r.failed {
+		t.Error("RunSeeds should detect order dependent results")
+	}
+}
+
+func TestClockThrottle(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	var times []time.Duration
+	pipe.Range(10).WithClock(clock).Throttle(2, 4).Each(func(i, index int) {
+		times = append(times, clock.Now().Sub(start))
+	})
+	// 4 at once from the burst, then one every half second
+	if fmt.Sprint(times) != "[0s 0s 0s 0s 500ms 1s 1.5s 2s 2.5s 3s]" {
+		t.Error("wrong throttled times", times)
+	}
+
+	clock = NewClock(start)
+	var mu sync.Mutex
+	count := 0
+	pipe.Range(100).Throttle(10, 1).WithClock(clock).PEach(func(i, index int) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+	// the sleeps of the workers add up on a fake clock, but at least one
+	// tenth of a second was waited per element
+	if count != 100 || clock.Slept() < 9900*time.Millisecond {
+		t.Error("wrong parallel throttle", count, clock.Slept())
+	}
+}
+
+func TestClockCircuitBreaker(t *testing.T) {
+	clock := NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	calls := 0
+	service := func(i int) int {
+		calls++
+		if i < 8 {
+			panic("service down")
+		}
+		return i
+	}
+	var changes []string
+	policy := pipe.BreakerPolicy{
+		Window:   4,
+		Cooldown: time.Second,
+		Fallback: func(i int) int { return -1 },
+		OnStateChange: func(from, to pipe.BreakerState) {
+			changes = append(changes, fmt.Sprint(from, "->", to))
+		},
+	}
+	var results []int
+	pipe.Range(16).Map(service).CircuitBreaker(policy).WithClock(clock).Each(func(i, index int) {
+		results = append(results, i)
+		clock.Advance(300 * time.Millisecond)
+	})
+	if fmt.Sprint(results) != "[-1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 11 12 13 14 15]" {
+		t.Error("wrong breaker results", results)
+	}
+	if fmt.Sprint(changes) != "[closed->open open->half-open half-open->open open->half-open half-open->closed]" || calls != 10 {
+		t.Error("wrong breaker state changes", changes, calls)
+	}
+
+	// without a fallback the failure stops the run, but the breaker stays
+	// open for the next run of the same pipe
+	p := pipe.Range(16).Map(service).CircuitBreaker(pipe.BreakerPolicy{Window: 1, Cooldown: time.Second}).
+		WithClock(clock).WithExecutor(pipe.InlineExecutor)
+	if _, err := p.PToSliceE(); errors.Is(err, pipe.ErrCircuitOpen) {
+		t.Error("the first failure should come from the service", err)
+	}
+	if _, err := p.PToSliceE(); !errors.Is(err, pipe.ErrCircuitOpen) {
+		t.Error("wrong open breaker error", err)
+	}
+}
+
+func TestRecorderTracer(t *testing.T) {
+	rec := NewRecorder()
+	p := pipe.Range(10).Filter(func(i int) bool { return i%3 != 0 }).Map(func(i int) int { return i * 2 }).
+		WithTracer(context.Background(), rec, pipe.TraceStages(), pipe.TraceBatches(4))
+	p.ToSlice()
+	spans := rec.Spans()
+	var names []string
+	for _, s := range spans {
+		if !s.Ended {
+			t.Error("span not ended", s.Name)
+		}
+		if s.Name != "pipe" && s.Parent != 0 {
+			t.Error("wrong parent", s.Name, s.Parent)
+		}
+		names = append(names, s.Name)
+	}
+	if len(spans) != 6 || spans[0].Name != "pipe" || spans[0].Parent != -1 || spans[0].Attrs["pipe.total"] != 10 {
+		t.Fatal("wrong spans", names)
+	}
+	if spans[1].Attrs["pipe.stage.in"] != 10 || spans[1].Attrs["pipe.stage.out"] != 6 ||
+		spans[2].Attrs["pipe.stage.in"] != 6 || spans[2].Attrs["pipe.stage.out"] != 6 {
+		t.Error("wrong stage spans", spans[1], spans[2])
+	}
+	if fmt.Sprint(names[3:]) != "[pipe batch 0 pipe batch 1 pipe batch 2]" || spans[5].Attrs["pipe.batch.size"] != 2 {
+		t.Error("wrong batch spans", names)
+	}
+
+	rec.Reset()
+	if _, err := p.WithExecutor(NewSeededScheduler(1)).PToSliceE(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Spans()) != 6 {
+		t.Error("wrong parallel spans", rec.Spans())
+	}
+
+	rec.Reset()
+	failing := pipe.Range(3).Map(func(i int) int { panic("boom") }).WithTracer(context.Background(), rec)
+	if _, err := failing.PToSliceE(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if spans := rec.Spans(); len(spans) != 1 || spans[0].Attrs["error"] == nil || !spans[0].Ended {
+		t.Error("wrong failed span", spans)
+	}
+}
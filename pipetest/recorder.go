@@ -0,0 +1,81 @@
+package pipetest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lennon-guan/pipe"
+)
+
+// RecordedSpan is a span kept by a Recorder. Parent is the index in
+// Recorder.Spans of the parent span, or -1 for a root span.
+type RecordedSpan struct {
+	Name   string
+	Parent int
+	Attrs  map[string]interface{}
+	Ended  bool
+}
+
+// Recorder is a pipe.Tracer keeping the spans it starts in memory.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+type _SpanKey struct{}
+
+type _Span struct {
+	r     *Recorder
+	index int
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) StartSpan(ctx context.Context, name string) (context.Context, pipe.Span) {
+	parent := -1
+	if s, ok := ctx.Value(_SpanKey{}).(*_Span); ok && s.r == r {
+		parent = s.index
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &_Span{r: r, index: len(r.spans)}
+	r.spans = append(r.spans, &RecordedSpan{Name: name, Parent: parent, Attrs: make(map[string]interface{})})
+	return context.WithValue(ctx, _SpanKey{}, span), span
+}
+
+func (s *_Span) SetAttr(key string, value interface{}) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.spans[s.index].Attrs[key] = value
+}
+
+func (s *_Span) End() {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.spans[s.index].Ended = true
+}
+
+// Spans returns copies of the spans started so far in the order they
+// were started.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make([]RecordedSpan, len(r.spans))
+	for i, s := range r.spans {
+		spans[i] = *s
+		spans[i].Attrs = make(map[string]interface{}, len(s.Attrs))
+		for k, v := range s.Attrs {
+			spans[i].Attrs[k] = v
+		}
+	}
+	return spans
+}
+
+// Reset forgets the spans started so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
@@ -0,0 +1,192 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// _Plan is the compiled form of a pipe: the root source together with the
+// flattened and optimized list of procs. It is built once per terminal pipe
+// and shared by every element, so getValue does not rebuild anything.
+type _Plan struct {
+	src      interface{}
+	srcValue reflect.Value
+	rng      *_Range
+	procs    []_IProc
+	// concurrent is set when procs contains a PMap or PFilter stage.
+	concurrent bool
+	// observer is set when the pipe has hooks or a progress callback.
+	observer *_Observer
+	// scans are the Scan stages of the plan and scanAt the index in procs
+	// of the first one, or len(procs); scanMu is held by the run using
+	// their accumulators.
+	scans  []*_ScanProc
+	scanAt int
+	scanMu sync.Mutex
+}
+
+type _StepFunc func(reflect.Value) (reflect.Value, bool)
+
+// _IFusable is implemented by procs that can be merged into a _FusedProc.
+type _IFusable interface {
+	_IProc
+	GetInType() reflect.Type
+	// step returns the call path of the proc. When checked is false the
+	// input type has already been verified while compiling the plan.
+	step(checked bool) _StepFunc
+}
+
+type _FusedProc struct {
+	OutType reflect.Type
+	steps   []_StepFunc
+}
+
+func (f *_FusedProc) Next(input reflect.Value) (reflect.Value, bool) {
+	for _, step := range f.steps {
+		var keep bool
+		if input, keep = step(input); !keep {
+			return input, false
+		}
+	}
+	return input, true
+}
+
+func (f *_FusedProc) GetOutType() reflect.Type {
+	return f.OutType
+}
+
+func (p *_Pipe) getPlan() *_Plan {
+	p.planOnce.Do(func() {
+		p.plan = p.compile()
+	})
+	return p.plan
+}
+
+func (p *_Pipe) compile() *_Plan {
+	var chain []_IProc
+	pp := p
+	for ; pp.srcPipe != nil; pp = pp.srcPipe {
+		if pp.proc != nil {
+			chain = append(chain, pp.proc)
+		}
+	}
+	if pp.arr == nil {
+		panic("no slice")
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	conf := p.config()
+	var scans []*_ScanProc
+	for i, proc := range chain {
+		if c, ok := proc.(_IConfigurable); ok {
+			chain[i] = c.configure(conf)
+		}
+		if s, ok := chain[i].(*_ScanProc); ok {
+			scans = append(scans, s)
+		}
+	}
+	if conf.deadLetters != nil {
+		p.guardProcs(chain, conf.deadLetters)
+	}
+	var observer *_Observer
+	if len(conf.hooks) > 0 || conf.progress != nil {
+		observer = p.newObserver(conf)
+		chain = p.observeProcs(chain, observer, pp.getOutType())
+	}
+	plan := &_Plan{
+		src:      pp.arr,
+		procs:    optimizeProcs(chain, pp.getOutType()),
+		observer: observer,
+		scans:    scans,
+	}
+	plan.scanAt = len(plan.procs)
+	for i, proc := range plan.procs {
+		if _, ok := proc.(*_ConcurrentProc); ok {
+			plan.concurrent = true
+		}
+		if isScanProc(proc) && i < plan.scanAt {
+			plan.scanAt = i
+		}
+	}
+	if r, ok := pp.arr.(*_Range); ok {
+		plan.rng = r
+	} else {
+		plan.srcValue = reflect.ValueOf(pp.arr)
+	}
+	return plan
+}
+
+// wrapProcs replaces every proc of chain, the last stages of p, by wrap of
+// it, passing its position in chain and its name as listed by Explain.
+// Identity maps are left alone and for PMap and PFilter stages the inner
+// proc is wrapped.
+func (p *_Pipe) wrapProcs(chain []_IProc, wrap func(i int, name string, inner _IProc) _IProc) {
+	_, stages := p.stages()
+	first := len(stages) - len(chain)
+	for i, proc := range chain {
+		if m, ok := proc.(*_MapProc); ok && !m.Func.IsValid() {
+			continue
+		}
+		name := fmt.Sprintf("#%d %v", first+i, stages[first+i])
+		if c, ok := proc.(*_ConcurrentProc); ok {
+			wrapped := *c
+			wrapped.inner = wrap(i, name, c.inner)
+			chain[i] = &wrapped
+		} else {
+			chain[i] = wrap(i, name, proc)
+		}
+	}
+}
+
+// optimizeProcs drops identity maps and fuses every run of adjacent
+// map/filter procs into a single _FusedProc. Type checks that can be proven
+// from the declared types are done here once instead of on every element.
+func optimizeProcs(chain []_IProc, srcType reflect.Type) []_IProc {
+	procs := make([]_IProc, 0, len(chain))
+	var fused *_FusedProc
+	inType := srcType
+	for _, proc := range chain {
+		if m, ok := proc.(*_MapProc); ok && !m.Func.IsValid() {
+			continue
+		}
+		f, ok := proc.(_IFusable)
+		if !ok {
+			fused = nil
+			procs = append(procs, proc)
+			inType = proc.GetOutType()
+			continue
+		}
+		if fused == nil {
+			fused = &_FusedProc{}
+			procs = append(procs, fused)
+		}
+		checked := !isTypeMatched(f.GetInType(), inType)
+		fused.steps = append(fused.steps, f.step(checked))
+		fused.OutType = f.GetOutType()
+		inType = fused.OutType
+	}
+	return procs
+}
+
+func (plan *_Plan) srcLen() int {
+	if r := plan.rng; r != nil {
+		return (r.end - r.begin) / r.step
+	}
+	return plan.srcValue.Len()
+}
+
+func (plan *_Plan) getValue(index int) *_GetValueTask {
+	var startValue reflect.Value
+	if r := plan.rng; r != nil {
+		startValue = reflect.ValueOf(r.begin + index*r.step)
+	} else {
+		startValue = plan.srcValue.Index(index)
+	}
+	return &_GetValueTask{
+		srcIndex:   index,
+		startValue: startValue,
+		procList:   plan.procs,
+	}
+}
@@ -0,0 +1,336 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// _ConcurrentProc runs its inner proc on up to workers goroutines. In a
+// sequential terminal the elements stream through a pool of that size; in a
+// P* terminal, where elements already run concurrently, it only limits how
+// many of them are inside the inner proc at once.
+type _ConcurrentProc struct {
+	inner   _IProc
+	workers int
+	ordered bool
+	sem     chan struct{}
+}
+
+func newConcurrentProc(inner _IProc, workers int) *_ConcurrentProc {
+	if workers < 1 {
+		workers = 1
+	}
+	return &_ConcurrentProc{
+		inner:   inner,
+		workers: workers,
+		ordered: true,
+		sem:     make(chan struct{}, workers),
+	}
+}
+
+func (c *_ConcurrentProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return c.nextAt(-1, input)
+}
+
+func (c *_ConcurrentProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	c.sem <- struct{}{}
+	defer func() {
+		<-c.sem
+	}()
+	return procNext(c.inner, index, input)
+}
+
+func (c *_ConcurrentProc) GetOutType() reflect.Type {
+	return c.inner.GetOutType()
+}
+
+func (c *_ConcurrentProc) configure(conf *_Config) _IProc {
+	if inner, ok := c.inner.(_IConfigurable); ok {
+		configured := *c
+		configured.inner = inner.configure(conf)
+		return &configured
+	}
+	return c
+}
+
+func (c *_ConcurrentProc) describe() _StageDesc {
+	desc := describeProc(c.inner)
+	if c.ordered {
+		desc.Kind = fmt.Sprintf("p%s(%d)", desc.Kind, c.workers)
+	} else {
+		desc.Kind = fmt.Sprintf("p%s(%d, unordered)", desc.Kind, c.workers)
+	}
+	return desc
+}
+
+// PMap is Map running fn on workers goroutines. Results keep the source
+// order unless followed by Unordered.
+func (p *_Pipe) PMap(proc interface{}, workers int) *_Pipe {
+	return p.addStage("pmap", proc, []interface{}{p.getOutType()}, []interface{}{nil}, func() _IProc {
+		return newConcurrentProc(newMapProc(proc, p.getOutType()), workers)
+	})
+}
+
+// PFilter is Filter running fn on workers goroutines. Results keep the
+// source order unless followed by Unordered.
+func (p *_Pipe) PFilter(proc interface{}, workers int) *_Pipe {
+	return p.addStage("pfilter", proc, []interface{}{p.getOutType()}, []interface{}{reflect.Bool}, func() _IProc {
+		return newConcurrentProc(newFilterProc(proc), workers)
+	})
+}
+
+// Unordered lets the preceding PMap or PFilter stage pass its results on as
+// soon as they are ready instead of in source order.
+func (p *_Pipe) Unordered() *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
+	stage, rebuild := p.lastStage()
+	c, ok := stage.proc.(*_ConcurrentProc)
+	if !ok {
+		actual := "source"
+		if stage.proc != nil {
+			actual = describeProc(stage.proc).Kind
+		}
+		return p.withError(&PipeError{
+			Stage:    p.stageCount(),
+			Kind:     "unordered",
+			Expected: "a preceding PMap or PFilter stage",
+			Actual:   actual,
+		})
+	}
+	unordered := *c
+	unordered.ordered = false
+	return rebuild(&_Pipe{
+		srcPipe: stage.srcPipe,
+		proc:    &unordered,
+	})
+}
+
+// sRun feeds the kept elements of p to sink in order on the calling
+// goroutine until sink returns false.
+func (p *_Pipe) sRun(sink func(int, reflect.Value) bool) {
+	plan := p.getPlan()
+	if plan.concurrent {
+		plan.checkScanOrder()
+	}
+	defer plan.beginScans()()
+	deadline := p.config().deadline
+	if obs := plan.observer; obs != nil {
+		obs.start(plan.srcLen())
+		defer func() {
+			r := recover()
+			obs.finish(panicErr(r))
+			if r != nil {
+				panic(r)
+			}
+		}()
+	}
+	if plan.concurrent {
+		plan.stream(deadline, sink)
+		return
+	}
+	length := plan.srcLen()
+	for i := 0; i < length; i++ {
+		// checked for every element, also the ones the stages drop
+		if expired(deadline) {
+			panic(ErrDeadline)
+		}
+		if item, keep := plan.getValue(i).GetValue(); keep && !sink(i, item) {
+			return
+		}
+	}
+}
+
+type _Item struct {
+	index int
+	value reflect.Value
+}
+
+// _Stream connects the goroutines of a streamed run. done is closed when
+// the consumer stops or a goroutine panics.
+type _Stream struct {
+	run     *_ParallelRun
+	wg      sync.WaitGroup
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	cancels []func()
+}
+
+func (s *_Stream) stop() {
+	s.once.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, cancel := range s.cancels {
+			cancel()
+		}
+	})
+}
+
+func (s *_Stream) onStop(cancel func()) {
+	s.mu.Lock()
+	s.cancels = append(s.cancels, cancel)
+	s.mu.Unlock()
+}
+
+func (s *_Stream) send(out chan<- _Item, item _Item) bool {
+	select {
+	case out <- item:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// expired tells whether deadline, if set, has passed.
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// stream runs a plan containing concurrent stages as a chain of goroutines
+// connected by channels: the runs of ordinary procs between concurrent
+// stages each get one goroutine, every concurrent stage gets its pool. The
+// source stops with ErrDeadline once deadline has passed.
+func (plan *_Plan) stream(deadline time.Time, sink func(int, reflect.Value) bool) {
+	s := &_Stream{
+		run:  newParallelRun(),
+		done: make(chan struct{}),
+	}
+	s.run.stop = s.stop
+	defer func() {
+		s.stop()
+		s.wg.Wait()
+		if err := s.run.Err(); err != nil {
+			panic(err)
+		}
+	}()
+	ch := s.source(plan, deadline)
+	var procs []_IProc
+	for _, proc := range plan.procs {
+		if c, ok := proc.(*_ConcurrentProc); ok {
+			ch = c.start(s, s.inline(ch, procs))
+			procs = nil
+		} else {
+			procs = append(procs, proc)
+		}
+	}
+	ch = s.inline(ch, procs)
+	for item := range ch {
+		if !sink(item.index, item.value) {
+			return
+		}
+	}
+}
+
+func (s *_Stream) source(plan *_Plan, deadline time.Time) <-chan _Item {
+	out := make(chan _Item)
+	length := plan.srcLen()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(out)
+		for i := 0; i < length; i++ {
+			if expired(deadline) {
+				s.run.fail(ErrDeadline)
+				return
+			}
+			if !s.send(out, _Item{i, plan.getValue(i).startValue}) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *_Stream) inline(in <-chan _Item, procs []_IProc) <-chan _Item {
+	if len(procs) == 0 {
+		return in
+	}
+	out := make(chan _Item)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(out)
+		for item := range in {
+			task := &_GetValueTask{srcIndex: item.index, startValue: item.value, procList: procs}
+			keep := false
+			s.run.call(item.index, func() {
+				item.value, keep = task.GetValue()
+			})
+			if s.run.Stopped() {
+				return
+			}
+			if keep && !s.send(out, item) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+type _SeqItem struct {
+	seq  int
+	item _Item
+}
+
+func (c *_ConcurrentProc) start(s *_Stream, in <-chan _Item) <-chan _Item {
+	out := make(chan _Item)
+	work := make(chan _SeqItem)
+	var wait *WaitIndex
+	if c.ordered {
+		wait = NewWaitIndex(-1, c.workers)
+		s.onStop(wait.Cancel)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(work)
+		seq := 0
+		for item := range in {
+			if wait != nil && !wait.Acquire(seq) {
+				return
+			}
+			select {
+			case work <- _SeqItem{seq, item}:
+			case <-s.done:
+				return
+			}
+			seq++
+		}
+	}()
+	var workers sync.WaitGroup
+	workers.Add(c.workers)
+	for w := 0; w < c.workers; w++ {
+		go func() {
+			defer workers.Done()
+			for si := range work {
+				item := si.item
+				keep := false
+				s.run.call(item.index, func() {
+					item.value, keep = procNext(c.inner, item.index, item.value)
+				})
+				emit := func() {
+					if keep && !s.run.Stopped() {
+						s.send(out, item)
+					}
+				}
+				if wait != nil {
+					wait.Done(si.seq, emit)
+				} else {
+					emit()
+				}
+			}
+		}()
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		workers.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,152 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RetryPolicy configures MapRetry. The zero value calls the function once.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of calls per element.
+	MaxAttempts int
+	// Backoff is the wait before the second attempt. Each following wait
+	// is Multiplier (2 if not greater than 1) times longer, up to
+	// MaxBackoff if it is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+	// Jitter in [0, 1] shortens every wait by a random fraction of at most
+	// Jitter, so failing elements do not retry in lockstep.
+	Jitter float64
+	// Retryable reports whether an error is worth another attempt. When
+	// nil every error is.
+	Retryable func(error) bool
+	// AttemptTimeout, if set, bounds the context passed to each attempt.
+	// Only functions taking a context.Context can honor it.
+	AttemptTimeout time.Duration
+	// Sleep waits between attempts; time.Sleep when nil.
+	Sleep func(time.Duration)
+}
+
+func (policy *RetryPolicy) wait(retry int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	d := float64(policy.Backoff)
+	for i := 1; i < retry; i++ {
+		d *= multiplier
+		if policy.MaxBackoff > 0 && d >= float64(policy.MaxBackoff) {
+			break
+		}
+	}
+	if policy.MaxBackoff > 0 && d > float64(policy.MaxBackoff) {
+		d = float64(policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 {
+		d -= d * policy.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// RetryError is the panic value of a MapRetry stage whose function still
+// fails after its last attempt. P* terminals wrap it in a *PanicError.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("pipe: map failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+type _RetryProc struct {
+	InType  reflect.Type
+	OutType reflect.Type
+	Func    reflect.Value
+	withCtx bool
+	policy  RetryPolicy
+}
+
+func (r *_RetryProc) Next(input reflect.Value) (reflect.Value, bool) {
+	attempts := r.policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	sleep := r.policy.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		var out reflect.Value
+		out, err = r.attempt(input)
+		if err == nil {
+			return out, true
+		}
+		if attempt == attempts || (r.policy.Retryable != nil && !r.policy.Retryable(err)) {
+			panic(&RetryError{Attempts: attempt, Err: err})
+		}
+		sleep(r.policy.wait(attempt))
+	}
+}
+
+func (r *_RetryProc) attempt(input reflect.Value) (reflect.Value, error) {
+	args := []reflect.Value{input}
+	if r.withCtx {
+		ctx := context.Background()
+		if r.policy.AttemptTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.policy.AttemptTimeout)
+			defer cancel()
+		}
+		args = []reflect.Value{reflect.ValueOf(ctx), input}
+	}
+	outs := r.Func.Call(args)
+	if err, _ := outs[1].Interface().(error); err != nil {
+		return reflect.Value{}, err
+	}
+	return outs[0], nil
+}
+
+func (r *_RetryProc) GetOutType() reflect.Type {
+	return r.OutType
+}
+
+func (r *_RetryProc) describe() _StageDesc {
+	return _StageDesc{Kind: "mapretry", InType: r.InType, OutType: r.OutType, Func: r.Func}
+}
+
+// MapRetry is Map for a function returning (U, error), called again with
+// policy's backoff while it fails. fn is either func(T) (U, error) or
+// func(context.Context, T) (U, error). The waits happen on the goroutine
+// handling the element, so in P* terminals other elements keep going.
+func (p *_Pipe) MapRetry(fn interface{}, policy RetryPolicy) *_Pipe {
+	intypes := []interface{}{p.getOutType()}
+	fType := reflect.TypeOf(fn)
+	withCtx := fType != nil && fType.Kind() == reflect.Func && fType.NumIn() == 2
+	if withCtx {
+		intypes = []interface{}{contextType, p.getOutType()}
+	}
+	return p.addStage("mapretry", fn, intypes, []interface{}{nil, errorType}, func() _IProc {
+		return &_RetryProc{
+			InType:  fType.In(len(intypes) - 1),
+			OutType: fType.Out(0),
+			Func:    reflect.ValueOf(fn),
+			withCtx: withCtx,
+			policy:  policy,
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package pipe
+
+import (
+	"errors"
+	"reflect"
+)
+
+// ErrUnorderedScan is raised by terminals that would not give a Scan stage
+// its elements in source order: the P* terminals handing elements over as
+// they finish, the ByKey ones, and any terminal after an Unordered stage
+// preceding the Scan.
+var ErrUnorderedScan = errors.New("pipe: Scan needs its elements in source order, which this terminal does not keep")
+
+// _ScanState is the accumulator of a Scan stage for one run at a time.
+type _ScanState struct {
+	acc reflect.Value
+}
+
+// _ScanProc replaces every element by the accumulator updated with it.
+type _ScanProc struct {
+	InType  reflect.Type
+	AccType reflect.Type
+	Init    reflect.Value
+	Func    reflect.Value
+	state   *_ScanState
+}
+
+func (s *_ScanProc) Next(input reflect.Value) (reflect.Value, bool) {
+	s.state.acc = s.Func.Call([]reflect.Value{s.state.acc, input})[0]
+	return s.state.acc, true
+}
+
+func (s *_ScanProc) GetOutType() reflect.Type {
+	return s.AccType
+}
+
+// configure gives every plan its own accumulator.
+func (s *_ScanProc) configure(conf *_Config) _IProc {
+	configured := *s
+	configured.state = &_ScanState{}
+	return &configured
+}
+
+func (s *_ScanProc) describe() _StageDesc {
+	return _StageDesc{Kind: "scan", InType: s.InType, OutType: s.AccType, Func: s.Func}
+}
+
+// isScanProc tells whether proc is a Scan stage, possibly wrapped by the
+// plan.
+func isScanProc(proc _IProc) bool {
+	for {
+		switch p := proc.(type) {
+		case *_ScanProc:
+			return true
+		case *_HookProc:
+			proc = p.inner
+		case *_GuardProc:
+			proc = p.inner
+		default:
+			return false
+		}
+	}
+}
+
+// beginScans resets the accumulators of the Scan stages of the plan for a
+// new run and returns the function ending it. Runs of the same pipe with
+// Scan stages wait for each other.
+func (plan *_Plan) beginScans() func() {
+	if len(plan.scans) == 0 {
+		return func() {}
+	}
+	plan.scanMu.Lock()
+	for _, s := range plan.scans {
+		s.state.acc = s.Init
+	}
+	return plan.scanMu.Unlock
+}
+
+// checkScanOrder panics with ErrUnorderedScan when an Unordered stage comes
+// before the first Scan stage of the plan.
+func (plan *_Plan) checkScanOrder() {
+	if len(plan.scans) == 0 {
+		return
+	}
+	for _, proc := range plan.procs[:plan.scanAt] {
+		if c, ok := proc.(*_ConcurrentProc); ok && !c.ordered {
+			panic(ErrUnorderedScan)
+		}
+	}
+}
+
+// Scan replaces every element by the accumulator fn(acc, item) returns for
+// it, starting from init: fn is a func(A, T) A and init an A, or nil for the
+// zero A. Sequential terminals and the P* terminals keeping source order
+// apply it element by element in source order, the stages before it still
+// running concurrently in the latter; other terminals fail with
+// ErrUnorderedScan. Every terminal starts again from init.
+func (p *_Pipe) Scan(init, fn interface{}) *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
+	if err := p.checkFunc("scan", fn, []interface{}{nil, p.getOutType()}, []interface{}{nil}); err != nil {
+		return p.withError(err)
+	}
+	fType := reflect.TypeOf(fn)
+	accType := fType.Out(0)
+	initValue := reflect.Zero(accType)
+	if init != nil {
+		initValue = reflect.ValueOf(init)
+	}
+	if fType.In(0) != accType || !initValue.Type().AssignableTo(accType) {
+		return p.withError(&PipeError{
+			Stage:    p.stageCount(),
+			Kind:     "scan",
+			Expected: formatSignature([]interface{}{initValue.Type(), p.getOutType()}, []interface{}{initValue.Type()}),
+			Actual:   fType.String(),
+		})
+	}
+	if initValue.Type() != accType {
+		initValue = initValue.Convert(accType)
+	}
+	return &_Pipe{
+		srcPipe: p,
+		proc: &_ScanProc{
+			InType:  p.getOutType(),
+			AccType: accType,
+			Init:    initValue,
+			Func:    reflect.ValueOf(fn),
+		},
+	}
+}
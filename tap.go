@@ -0,0 +1,72 @@
+package pipe
+
+import (
+	"reflect"
+)
+
+// _TapProc calls its function with every element and passes the element
+// on unchanged.
+type _TapProc struct {
+	Type    reflect.Type
+	Func    reflect.Value
+	indexed bool
+}
+
+func (t *_TapProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	if t.indexed {
+		t.Func.Call([]reflect.Value{input, reflect.ValueOf(index)})
+	} else {
+		t.Func.Call([]reflect.Value{input})
+	}
+	return input, true
+}
+
+func (t *_TapProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return t.nextAt(-1, input)
+}
+
+func (t *_TapProc) GetOutType() reflect.Type {
+	return t.Type
+}
+
+// configure turns the tap into an identity map, which the plan drops, when
+// the taps of the pipe are disabled.
+func (t *_TapProc) configure(conf *_Config) _IProc {
+	if conf.noTaps {
+		return &_MapProc{InType: t.Type, OutType: t.Type}
+	}
+	return t
+}
+
+func (t *_TapProc) describe() _StageDesc {
+	kind := "tap"
+	if t.indexed {
+		kind = "tapindexed"
+	}
+	return _StageDesc{Kind: kind, InType: t.Type, OutType: t.Type, Func: t.Func}
+}
+
+// Tap calls fn with every element reaching it and passes the element on
+// unchanged. In P* terminals fn is called on the goroutine handling the
+// element, so it may be called concurrently.
+func (p *_Pipe) Tap(fn interface{}) *_Pipe {
+	return p.addStage("tap", fn, []interface{}{p.getOutType()}, []interface{}{}, func() _IProc {
+		return &_TapProc{Type: p.getOutType(), Func: reflect.ValueOf(fn)}
+	})
+}
+
+// TapIndexed is Tap for a func(T, int) also given the source index of the
+// element.
+func (p *_Pipe) TapIndexed(fn interface{}) *_Pipe {
+	return p.addStage("tapindexed", fn, []interface{}{p.getOutType(), reflect.Int}, []interface{}{}, func() _IProc {
+		return &_TapProc{Type: p.getOutType(), Func: reflect.ValueOf(fn), indexed: true}
+	})
+}
+
+// WithTaps enables or disables the Tap and TapIndexed stages of the pipe.
+// Disabled taps are left out of the plan, so they cost nothing.
+func (p *_Pipe) WithTaps(enabled bool) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.noTaps = !enabled
+	})
+}
@@ -0,0 +1,98 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// _ThrottleProc passes elements on unchanged, at most rate per second on
+// average with bursts of up to burst elements. The bucket is shared by all
+// the workers of a terminal.
+type _ThrottleProc struct {
+	Type  reflect.Type
+	rate  float64
+	burst int
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (t *_ThrottleProc) configure(conf *_Config) _IProc {
+	return &_ThrottleProc{
+		Type:   t.Type,
+		rate:   t.rate,
+		burst:  t.burst,
+		clock:  conf.clock,
+		tokens: float64(t.burst),
+		last:   conf.clock.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait before it is
+// available. Tokens can be reserved ahead, so concurrent callers queue up
+// instead of all retrying at once.
+func (t *_ThrottleProc) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock.Now()
+	if now.After(t.last) {
+		t.tokens += now.Sub(t.last).Seconds() * t.rate
+		if t.tokens > float64(t.burst) {
+			t.tokens = float64(t.burst)
+		}
+		t.last = now
+	}
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+func (t *_ThrottleProc) Next(input reflect.Value) (reflect.Value, bool) {
+	if wait := t.reserve(); wait > 0 {
+		t.clock.Sleep(wait)
+	}
+	return input, true
+}
+
+func (t *_ThrottleProc) GetOutType() reflect.Type {
+	return t.Type
+}
+
+func (t *_ThrottleProc) describe() _StageDesc {
+	return _StageDesc{Kind: fmt.Sprintf("throttle(%g/s, %d)", t.rate, t.burst), InType: t.Type, OutType: t.Type}
+}
+
+// Throttle limits the rate at which elements go on to the following stages
+// to rate per second, letting up to burst of them through at once after an
+// idle period. In P* terminals the limit is shared by all the workers. The
+// clock set by WithClock is used to wait.
+func (p *_Pipe) Throttle(rate float64, burst int) *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
+	if rate <= 0 {
+		return p.withError(&PipeError{
+			Stage:    p.stageCount(),
+			Kind:     "throttle",
+			Expected: "a positive rate",
+			Actual:   fmt.Sprint(rate),
+		})
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &_Pipe{
+		srcPipe: p,
+		proc: &_ThrottleProc{
+			Type:  p.getOutType(),
+			rate:  rate,
+			burst: burst,
+		},
+	}
+}
@@ -0,0 +1,219 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var (
+	// ErrTimeout is the panic value of a stage failing an element after its
+	// Timeout. P* terminals wrap it in a *PanicError.
+	ErrTimeout = errors.New("pipe: element timed out")
+	// ErrDeadline is returned by the error-aware P* terminals, and raised
+	// by the other terminals, when the deadline set by WithDeadline passes.
+	ErrDeadline = errors.New("pipe: deadline exceeded")
+)
+
+const (
+	timeoutFail = iota
+	timeoutDrop
+	timeoutFallback
+)
+
+// TimeoutAction tells a stage with a Timeout what to do with an element
+// taking too long.
+type TimeoutAction struct {
+	kind     int
+	fallback interface{}
+}
+
+var (
+	// TimeoutFail stops the terminal with ErrTimeout.
+	TimeoutFail = TimeoutAction{kind: timeoutFail}
+	// TimeoutDrop leaves the element out.
+	TimeoutDrop = TimeoutAction{kind: timeoutDrop}
+)
+
+// TimeoutFallback passes v on instead of the result of the element.
+func TimeoutFallback(v interface{}) TimeoutAction {
+	return TimeoutAction{kind: timeoutFallback, fallback: v}
+}
+
+func (a TimeoutAction) String() string {
+	switch a.kind {
+	case timeoutDrop:
+		return "drop"
+	case timeoutFallback:
+		return fmt.Sprintf("fallback %v", a.fallback)
+	default:
+		return "fail"
+	}
+}
+
+// _TimeoutProc runs its inner proc on a goroutine of its own and stops
+// waiting for it after d. The abandoned call keeps running in the
+// background; its result is discarded.
+type _TimeoutProc struct {
+	inner    _IProc
+	d        time.Duration
+	action   TimeoutAction
+	fallback reflect.Value
+}
+
+type _TimeoutResult struct {
+	value reflect.Value
+	keep  bool
+	panic interface{}
+}
+
+func (t *_TimeoutProc) Next(input reflect.Value) (reflect.Value, bool) {
+	return t.nextAt(-1, input)
+}
+
+func (t *_TimeoutProc) nextAt(index int, input reflect.Value) (reflect.Value, bool) {
+	done := make(chan _TimeoutResult, 1)
+	go func() {
+		var result _TimeoutResult
+		defer func() {
+			if r := recover(); r != nil {
+				result.panic = r
+			}
+			done <- result
+		}()
+		result.value, result.keep = procNext(t.inner, index, input)
+	}()
+	timer := time.NewTimer(t.d)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		if result.panic != nil {
+			panic(result.panic)
+		}
+		return result.value, result.keep
+	case <-timer.C:
+	}
+	switch t.action.kind {
+	case timeoutDrop:
+		return input, false
+	case timeoutFallback:
+		return t.fallback, true
+	default:
+		panic(ErrTimeout)
+	}
+}
+
+func (t *_TimeoutProc) GetOutType() reflect.Type {
+	return t.inner.GetOutType()
+}
+
+func (t *_TimeoutProc) configure(conf *_Config) _IProc {
+	if c, ok := t.inner.(_IConfigurable); ok {
+		configured := *t
+		configured.inner = c.configure(conf)
+		return &configured
+	}
+	return t
+}
+
+func (t *_TimeoutProc) describe() _StageDesc {
+	desc := describeProc(t.inner)
+	desc.Kind = fmt.Sprintf("%s timeout(%v, %v)", desc.Kind, t.d, t.action)
+	return desc
+}
+
+// Timeout limits the time the preceding stage may spend on one element to
+// d. An element taking longer is dropped, replaced or fails the terminal
+// depending on action; the call itself cannot be interrupted and finishes
+// in the background.
+func (p *_Pipe) Timeout(d time.Duration, action TimeoutAction) *_Pipe {
+	stage, _ := p.lastStage()
+	if p.err != nil || stage.proc == nil {
+		return p.wrapStage("timeout", nil)
+	}
+	outType := stage.proc.GetOutType()
+	var fallback reflect.Value
+	if action.kind == timeoutFallback {
+		fallback = reflect.ValueOf(action.fallback)
+		if !fallback.IsValid() {
+			fallback = reflect.Zero(outType)
+		} else if !isTypeMatched(outType, fallback.Type()) {
+			return p.withError(&PipeError{
+				Stage:    p.stageCount(),
+				Kind:     "timeout fallback",
+				Expected: outType.String(),
+				Actual:   fallback.Type().String(),
+			})
+		}
+	}
+	return p.wrapStage("timeout", func(inner _IProc) _IProc {
+		return &_TimeoutProc{inner: inner, d: d, action: action, fallback: fallback}
+	})
+}
+
+// wrapStage replaces the preceding stage by wrap of it. For a PMap or
+// PFilter stage the function call is wrapped, inside the worker pool.
+func (p *_Pipe) wrapStage(kind string, wrap func(_IProc) _IProc) *_Pipe {
+	if p.err != nil {
+		return p.withError(p.err)
+	}
+	stage, rebuild := p.lastStage()
+	if stage.proc == nil {
+		return p.withError(&PipeError{
+			Stage:    p.stageCount(),
+			Kind:     kind,
+			Expected: "a preceding stage",
+			Actual:   "source",
+		})
+	}
+	if _, ok := stage.proc.(*_ScanProc); ok {
+		return p.withError(&PipeError{
+			Stage:    p.stageCount(),
+			Kind:     kind,
+			Expected: "a preceding stage other than Scan",
+			Actual:   "scan",
+		})
+	}
+	var proc _IProc
+	if c, ok := stage.proc.(*_ConcurrentProc); ok {
+		wrapped := *c
+		wrapped.inner = wrap(c.inner)
+		proc = &wrapped
+	} else {
+		proc = wrap(stage.proc)
+	}
+	return rebuild(&_Pipe{
+		srcPipe: stage.srcPipe,
+		proc:    proc,
+	})
+}
+
+// lastStage returns the nearest node of p holding a stage, skipping the
+// nodes that only hold settings, or the source when there is no stage.
+// rebuild puts the skipped nodes back on top of a replacement of it.
+func (p *_Pipe) lastStage() (stage *_Pipe, rebuild func(*_Pipe) *_Pipe) {
+	var confs []*_Pipe
+	stage = p
+	for stage.proc == nil && stage.conf != nil && stage.srcPipe != nil {
+		confs = append(confs, stage)
+		stage = stage.srcPipe
+	}
+	rebuild = func(q *_Pipe) *_Pipe {
+		for i := len(confs) - 1; i >= 0; i-- {
+			q = &_Pipe{srcPipe: q, err: q.err, conf: confs[i].conf}
+		}
+		return q
+	}
+	return stage, rebuild
+}
+
+// WithDeadline makes every terminal of the pipe give up at deadline. P*
+// terminals then stop at once with ErrDeadline, abandoning the elements
+// still being processed; sequential terminals check the deadline between
+// elements and panic with ErrDeadline.
+func (p *_Pipe) WithDeadline(deadline time.Time) *_Pipe {
+	return p.withConfig(func(conf *_Config) {
+		conf.deadline = deadline
+	})
+}
@@ -0,0 +1,200 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Tracer starts the spans the terminals of a pipe are reported with. The
+// pipeotel package adapts OpenTelemetry tracers and pipetest.Recorder
+// keeps the spans in memory.
+type Tracer interface {
+	// StartSpan starts a span named name as a child of the span in ctx and
+	// returns the context holding the new span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttr(key string, value interface{})
+	End()
+}
+
+// TraceOption adds spans to the ones of WithTracer.
+type TraceOption func(*_TraceHook)
+
+// TraceStages adds a span per stage, covering the whole terminal, with
+// the numbers of elements the stage kept and dropped.
+func TraceStages() TraceOption {
+	return func(h *_TraceHook) {
+		h.withStages = true
+	}
+}
+
+// TraceBatches adds a span per batch of size consecutive source elements,
+// from the first of them entering the stages to the last leaving them.
+func TraceBatches(size int) TraceOption {
+	return func(h *_TraceHook) {
+		if size < 1 {
+			size = 1
+		}
+		h.batchSize = size
+	}
+}
+
+type _StageSpan struct {
+	span    Span
+	in, out int
+}
+
+type _BatchSpan struct {
+	span Span
+	done int
+}
+
+// _TraceHook reports the runs of a pipe as spans: one per terminal, and
+// optionally per stage and per batch.
+type _TraceHook struct {
+	ctx        context.Context
+	tracer     Tracer
+	withStages bool
+	batchSize  int
+	source     string
+	stages     []string
+
+	mu       sync.Mutex
+	runCtx   context.Context
+	span     Span
+	total    int
+	stageMap map[string]*_StageSpan
+	batches  map[int]*_BatchSpan
+}
+
+func (h *_TraceHook) bind(p *_Pipe) Hook {
+	root, stages := p.stages()
+	bound := &_TraceHook{
+		ctx:        h.ctx,
+		tracer:     h.tracer,
+		withStages: h.withStages,
+		batchSize:  h.batchSize,
+		source:     root.describeSource(),
+	}
+	for i, stage := range stages {
+		bound.stages = append(bound.stages, fmt.Sprintf("#%d %v", i, stage))
+	}
+	return bound
+}
+
+func (h *_TraceHook) OnStart(total int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runCtx, h.span = h.tracer.StartSpan(h.ctx, "pipe")
+	h.span.SetAttr("pipe.source", h.source)
+	h.span.SetAttr("pipe.total", total)
+	h.total = total
+	h.stageMap = make(map[string]*_StageSpan)
+	h.batches = make(map[int]*_BatchSpan)
+	if h.withStages {
+		for _, name := range h.stages {
+			_, span := h.tracer.StartSpan(h.runCtx, name)
+			h.stageMap[name] = &_StageSpan{span: span}
+		}
+	}
+}
+
+func (h *_TraceHook) count(stage string, kept bool) {
+	if !h.withStages {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.stageMap[stage]; ok {
+		s.in++
+		if kept {
+			s.out++
+		}
+	}
+}
+
+// elementStart starts the span of the batch of index if needed.
+func (h *_TraceHook) elementStart(index int) {
+	if h.batchSize == 0 || index < 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.batchOf(index)
+}
+
+func (h *_TraceHook) batchOf(index int) *_BatchSpan {
+	n := index / h.batchSize
+	b, ok := h.batches[n]
+	if !ok {
+		_, span := h.tracer.StartSpan(h.runCtx, fmt.Sprintf("pipe batch %d", n))
+		span.SetAttr("pipe.batch.first", n*h.batchSize)
+		b = &_BatchSpan{span: span}
+		h.batches[n] = b
+	}
+	return b
+}
+
+func (h *_TraceHook) OnItem(stage string, in, out interface{}, d time.Duration) {
+	h.count(stage, true)
+}
+
+func (h *_TraceHook) OnDrop(stage string, in interface{}, d time.Duration) {
+	h.count(stage, false)
+}
+
+func (h *_TraceHook) elementDone(index int, kept bool) {
+	if h.batchSize == 0 || index < 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := h.batchOf(index)
+	b.done++
+	n := index / h.batchSize
+	size := h.batchSize
+	if rest := h.total - n*h.batchSize; rest < size {
+		size = rest
+	}
+	if b.done == size {
+		b.span.SetAttr("pipe.batch.size", size)
+		b.span.End()
+		delete(h.batches, n)
+	}
+}
+
+func (h *_TraceHook) OnFinish(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range h.stages {
+		if s, ok := h.stageMap[name]; ok {
+			s.span.SetAttr("pipe.stage.in", s.in)
+			s.span.SetAttr("pipe.stage.out", s.out)
+			s.span.End()
+		}
+	}
+	// batches cut short by a failure
+	for _, b := range h.batches {
+		b.span.End()
+	}
+	if err != nil {
+		h.span.SetAttr("error", err.Error())
+	}
+	h.span.End()
+}
+
+// WithTracer reports every terminal of the pipe as a span started by
+// tracer under the span in ctx, with more spans per stage or per batch
+// depending on opts. Like WithHook it stops the stages being fused.
+func (p *_Pipe) WithTracer(ctx context.Context, tracer Tracer, opts ...TraceOption) *_Pipe {
+	h := &_TraceHook{ctx: ctx, tracer: tracer}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return p.WithHook(h)
+}
@@ -1,39 +1,105 @@
 package pipe
 
+import (
+	"sync"
+)
+
+// WaitIndex delivers results that complete out of order strictly in index
+// order. Only indexes inside a window starting at the next index to deliver
+// may be in flight: Acquire blocks callers that run further ahead, so at
+// most window results are ever buffered.
+//
+// Every acquired index must be finished with Done, passing nil when there
+// is nothing to deliver. Deliveries run one at a time on the goroutine
+// whose Done completes the next index. Cancel stops all further deliveries
+// and releases blocked callers.
 type WaitIndex struct {
-	chs     []chan bool
-	allDone chan bool
+	mu         sync.Mutex
+	cond       *sync.Cond
+	total      int
+	window     int
+	next       int
+	pending    map[int]func()
+	delivering bool
+	cancelled  bool
 }
 
-func NewWaitIndex(total int) *WaitIndex {
-	chs := make([]chan bool, total)
-	for i := 0; i < total; i++ {
-		chs[i] = make(chan bool, 1)
+// NewWaitIndex creates a WaitIndex for indexes 0 to total-1. A negative
+// total means the number of indexes is not known in advance and Wait only
+// returns after Cancel. A window below 1 is treated as 1.
+func NewWaitIndex(total, window int) *WaitIndex {
+	if window < 1 {
+		window = 1
 	}
-	return &WaitIndex{
-		chs:     chs,
-		allDone: make(chan bool, 1),
+	wait := &WaitIndex{
+		total:   total,
+		window:  window,
+		pending: make(map[int]func(), window),
 	}
+	wait.cond = sync.NewCond(&wait.mu)
+	return wait
 }
 
-func (wait *WaitIndex) Wait(index int) {
-	if index >= 0 && index < len(wait.chs) {
-		<-wait.chs[index]
+// Acquire blocks until index is inside the window. It returns false if the
+// WaitIndex has been cancelled.
+func (wait *WaitIndex) Acquire(index int) bool {
+	wait.mu.Lock()
+	defer wait.mu.Unlock()
+	for !wait.cancelled && index >= wait.next+wait.window {
+		wait.cond.Wait()
 	}
+	return !wait.cancelled
 }
 
-func (wait *WaitIndex) Done(index int) {
-	if index >= 0 && index < len(wait.chs) {
-		wait.chs[index] <- true
-		if index == len(wait.chs)-1 {
-			wait.allDone <- true
+// Done records the result of index. deliver, if not nil, is called once
+// every lower index has been delivered.
+func (wait *WaitIndex) Done(index int, deliver func()) {
+	if deliver == nil {
+		deliver = func() {}
+	}
+	wait.mu.Lock()
+	if wait.cancelled {
+		wait.mu.Unlock()
+		return
+	}
+	wait.pending[index] = deliver
+	if wait.delivering {
+		wait.mu.Unlock()
+		return
+	}
+	wait.delivering = true
+	for !wait.cancelled {
+		fn, ok := wait.pending[wait.next]
+		if !ok {
+			break
 		}
+		delete(wait.pending, wait.next)
+		wait.mu.Unlock()
+		fn()
+		wait.mu.Lock()
+		wait.next++
+		wait.cond.Broadcast()
 	}
+	wait.delivering = false
+	wait.mu.Unlock()
+}
+
+// Cancel drops every buffered result and wakes up all blocked callers.
+func (wait *WaitIndex) Cancel() {
+	wait.mu.Lock()
+	wait.cancelled = true
+	wait.pending = nil
+	wait.cond.Broadcast()
+	wait.mu.Unlock()
 }
 
-func (wait *WaitIndex) WaitAndClose() {
-	<-wait.allDone
-	for _, ch := range wait.chs {
-		close(ch)
+// Wait blocks until every index has been delivered or the WaitIndex is
+// cancelled, and reports whether all of them were delivered.
+func (wait *WaitIndex) Wait() bool {
+	wait.mu.Lock()
+	defer wait.mu.Unlock()
+	for !wait.cancelled && (wait.total < 0 || wait.next < wait.total) {
+		wait.cond.Wait()
 	}
+	return !wait.cancelled
 }